@@ -3,8 +3,11 @@ module github.com/FastLane-Labs/fastlane-json-rpc
 go 1.22.0
 
 require (
+	github.com/golang-jwt/jwt/v4 v4.5.1
 	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.18.0
 	golang.org/x/text v0.22.0
+	golang.org/x/time v0.5.0
 )
 
 require (
@@ -16,6 +19,7 @@ require (
 	github.com/deckarep/golang-set/v2 v2.6.0 // indirect
 	github.com/felixge/httpsnoop v1.0.3 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/prometheus/client_model v0.6.1 // indirect
@@ -0,0 +1,233 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiKeyPathVar = "apiKey"
+	jwtSecretSize = 32
+)
+
+var (
+	ErrInvalidJWTSecret = errors.New("invalid JWT secret: 32 hex encoded bytes expected")
+	ErrInvalidAPIKey    = errors.New("invalid API key")
+	ErrNoCredentials    = errors.New("no JWT secret or API key configured")
+
+	errMissingCredentials = errors.New("missing credentials")
+	errUnknownAPIKey      = errors.New("unknown API key")
+	errMissingIssuedAt    = errors.New("missing issued-at claim")
+	errStaleToken         = errors.New("stale token")
+	errJWTNotConfigured   = errors.New("bearer tokens not accepted")
+)
+
+type principalContextKey struct{}
+
+// AuthScheme is the way a client authenticated.
+type AuthScheme string
+
+const (
+	AuthSchemeJWT    AuthScheme = "jwt"
+	AuthSchemeAPIKey AuthScheme = "api_key"
+)
+
+// Principal is the authenticated client of a request. Name is the name of its API key, or the subject of
+// its JWT if any. Claims holds the claims of its JWT.
+type Principal struct {
+	Name    string
+	Scheme  AuthScheme
+	Claims  map[string]interface{}
+	Methods []string // Methods the client can call, all if empty
+}
+
+// PrincipalFromContext returns the client a request was authenticated as, only set when authentication
+// is enabled.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+// withPrincipal stores principal in ctx, unless nil.
+func withPrincipal(ctx context.Context, principal *Principal) context.Context {
+	if principal == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// allows reports whether the principal can call method.
+func (p *Principal) allows(method string) bool {
+	if len(p.Methods) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.Methods {
+		if allowed == method || allowed == methodNamespace(method)+"_*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticator authenticates HTTP requests, including websocket upgrades.
+type authenticator struct {
+	cfg       *AuthConfig
+	jwtSecret []byte
+
+	// API keys are looked up by their hash, so that lookups don't leak the keys through timing
+	apiKeys map[[sha256.Size]byte]*Principal
+}
+
+func newAuthenticator(cfg *AuthConfig) (*authenticator, error) {
+	a := &authenticator{
+		cfg:     cfg,
+		apiKeys: make(map[[sha256.Size]byte]*Principal, len(cfg.APIKeys)),
+	}
+
+	secret := cfg.JWTSecret
+	if cfg.JWTSecretFile != "" {
+		data, err := os.ReadFile(cfg.JWTSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT secret: %w", err)
+		}
+		secret = string(data)
+	}
+
+	if secret != "" {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(secret), "0x"))
+		if err != nil || len(decoded) != jwtSecretSize {
+			return nil, ErrInvalidJWTSecret
+		}
+		a.jwtSecret = decoded
+	}
+
+	for _, key := range cfg.APIKeys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("%w: empty key %s", ErrInvalidAPIKey, key.Name)
+		}
+
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := a.apiKeys[hash]; ok {
+			return nil, fmt.Errorf("%w: duplicate key %s", ErrInvalidAPIKey, key.Name)
+		}
+
+		a.apiKeys[hash] = &Principal{
+			Name:    key.Name,
+			Scheme:  AuthSchemeAPIKey,
+			Methods: key.Methods,
+		}
+	}
+
+	if a.jwtSecret == nil && len(a.apiKeys) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	return a, nil
+}
+
+// authenticate returns the client sending r. A bearer token takes precedence over API keys.
+func (a *authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return a.verifyJWT(strings.TrimSpace(token))
+	}
+
+	if key := a.apiKey(r); key != "" {
+		if principal, ok := a.apiKeys[sha256.Sum256([]byte(key))]; ok {
+			return principal, nil
+		}
+		return nil, errUnknownAPIKey
+	}
+
+	return nil, errMissingCredentials
+}
+
+// apiKey returns the API key sent with r, from its header, query or path.
+func (a *authenticator) apiKey(r *http.Request) string {
+	if key := r.Header.Get(a.cfg.apiKeyHeader()); key != "" {
+		return key
+	}
+
+	if key := r.URL.Query().Get(a.cfg.apiKeyQueryParam()); key != "" {
+		return key
+	}
+
+	if a.cfg.APIKeyInPath {
+		return mux.Vars(r)[apiKeyPathVar]
+	}
+
+	return ""
+}
+
+// verifyJWT checks an HS256 token the way Engine API clients expect: the signature must match the secret and
+// the iat claim must be close to the current time. exp is checked if set.
+func (a *authenticator) verifyJWT(token string) (*Principal, error) {
+	if a.jwtSecret == nil {
+		return nil, errJWTNotConfigured
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return a.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, err
+	}
+
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return nil, errMissingIssuedAt
+	}
+
+	now := time.Now()
+	if skew := now.Sub(time.Unix(int64(iat), 0)).Abs(); skew > a.cfg.jwtIatSkew() {
+		return nil, fmt.Errorf("%w: issued %s away from now", errStaleToken, skew.Truncate(time.Second))
+	}
+
+	if !claims.VerifyExpiresAt(now.Unix(), false) {
+		return nil, jwt.ErrTokenExpired
+	}
+
+	principal := &Principal{
+		Name:   string(AuthSchemeJWT),
+		Scheme: AuthSchemeJWT,
+		Claims: claims,
+	}
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		principal.Name = sub
+	}
+
+	return principal, nil
+}
+
+// authenticateRequest authenticates r if authentication is enabled, answering 401 on failure.
+func (s *Server) authenticateRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	if s.auth == nil {
+		return nil, true
+	}
+
+	principal, err := s.auth.authenticate(r)
+	if err != nil {
+		// The reason is only logged, it would tell an attacker which keys or tokens are close to valid
+		log.Warn(ctx, "unauthorized request", "ip", r.RemoteAddr, "err", err)
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHttpError(w, http.StatusUnauthorized, jsonrpc.InvalidRequest, "unauthorized", nil)
+		return nil, false
+	}
+
+	return principal, true
+}
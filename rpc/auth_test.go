@@ -0,0 +1,339 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+var testJWTSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestJWT(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func newAuthTestServer(t *testing.T, authCfg *AuthConfig, discoveryCfg *DiscoveryConfig) *Server {
+	authCfg.Enabled = true
+
+	registry := NewRegistry()
+	assert.NoError(t, registry.Register("test_whoami", func(ctx context.Context) string {
+		principal, ok := PrincipalFromContext(ctx)
+		if !ok {
+			return ""
+		}
+		return principal.Name
+	}))
+	assert.NoError(t, registry.Register("test_echo", func(value string) string {
+		return value
+	}))
+	assert.NoError(t, registry.Register("admin_secret", func() string {
+		return "secret"
+	}))
+
+	return newTestServer(t, &RpcConfig{
+		HealthcheckEndpoint: "/health",
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+		Auth:      authCfg,
+		Discovery: discoveryCfg,
+	}, registry)
+}
+
+func dialWithHeader(url string, header http.Header) (*gethrpc.Client, error) {
+	var opts []gethrpc.ClientOption
+	for key := range header {
+		opts = append(opts, gethrpc.WithHeader(key, header.Get(key)))
+	}
+	return gethrpc.DialOptions(context.Background(), url, opts...)
+}
+
+func TestServer_AuthJWT(t *testing.T) {
+	t.Parallel()
+
+	// Engine API jwtsecret file
+	secretFile := filepath.Join(t.TempDir(), "jwtsecret")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("0x"+hex.EncodeToString(testJWTSecret)+"\n"), 0600))
+
+	s := newAuthTestServer(t, &AuthConfig{JWTSecretFile: secretFile}, nil)
+	url := "http://" + s.Addr().String()
+
+	now := time.Now()
+
+	testCases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": now.Unix()}), true},
+		{"within skew", newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": now.Add(-50 * time.Second).Unix()}), true},
+		{"stale", newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": now.Add(-2 * time.Minute).Unix()}), false},
+		{"future", newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": now.Add(2 * time.Minute).Unix()}), false},
+		{"missing iat", newTestJWT(t, testJWTSecret, jwt.MapClaims{}), false},
+		{"expired", newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": now.Unix(), "exp": now.Add(-time.Second).Unix()}), false},
+		{"wrong secret", newTestJWT(t, []byte("fedcba9876543210fedcba9876543210"), jwt.MapClaims{"iat": now.Unix()}), false},
+		{"unsigned", func() string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"iat": now.Unix()}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return token
+		}(), false},
+		{"missing", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.token != "" {
+				header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			rpcClient, err := dialWithHeader(url, header)
+			if err != nil {
+				t.Fatalf("failed to create rpc client: %v", err)
+			}
+
+			defer rpcClient.Close()
+
+			var result string
+			err = rpcClient.Call(&result, "test_whoami")
+			if !tc.ok {
+				var httpErr gethrpc.HTTPError
+				if assert.ErrorAs(t, err, &httpErr) {
+					assert.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, string(AuthSchemeJWT), result)
+		})
+	}
+
+	// The subject of the token names the principal
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": now.Unix(), "sub": "builder"}))
+
+	rpcClient, err := dialWithHeader(url, header)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	var result string
+	assert.NoError(t, rpcClient.Call(&result, "test_whoami"))
+	assert.Equal(t, "builder", result)
+
+	// The healthcheck is not authenticated
+	resp, err := http.Get(url + "/health")
+	if err != nil {
+		t.Fatalf("failed to get healthcheck: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServer_AuthAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	s := newAuthTestServer(t, &AuthConfig{
+		APIKeys: []APIKeyConfig{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key", Methods: []string{"test_whoami"}},
+			{Name: "carol", Key: "carol-key", Methods: []string{"test_*"}},
+		},
+		APIKeyInPath: true,
+	}, &DiscoveryConfig{Enabled: true})
+	addr := s.Addr().String()
+
+	testCases := []struct {
+		name     string
+		url      string
+		header   http.Header
+		expected string
+	}{
+		{"header", "http://" + addr, http.Header{"X-Api-Key": {"alice-key"}}, "alice"},
+		{"query", "http://" + addr + "/?apikey=alice-key", nil, "alice"},
+		{"path", "http://" + addr + "/alice-key", nil, "alice"},
+		{"unknown", "http://" + addr + "/?apikey=eve-key", nil, ""},
+		{"missing", "http://" + addr, nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rpcClient, err := dialWithHeader(tc.url, tc.header)
+			if err != nil {
+				t.Fatalf("failed to create rpc client: %v", err)
+			}
+
+			defer rpcClient.Close()
+
+			var result string
+			err = rpcClient.Call(&result, "test_whoami")
+			if tc.expected == "" {
+				var httpErr gethrpc.HTTPError
+				if assert.ErrorAs(t, err, &httpErr) {
+					assert.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+
+	// Methods outside the allowlist of a key are not found, including in batches
+	allowed := map[string][]string{
+		"alice": {"admin_secret", "test_echo", "test_whoami"},
+		"bob":   {"test_whoami"},
+		"carol": {"test_echo", "test_whoami"},
+	}
+
+	for name, methods := range allowed {
+		rpcClient, err := gethrpc.Dial("http://" + addr + "/" + name + "-key")
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		batch := []gethrpc.BatchElem{
+			{Method: "admin_secret", Result: new(string)},
+			{Method: "test_echo", Args: []interface{}{"hello"}, Result: new(string)},
+			{Method: "test_whoami", Result: new(string)},
+		}
+		assert.NoError(t, rpcClient.BatchCall(batch), name)
+
+		for _, elem := range batch {
+			var rpcErr gethrpc.Error
+			if slices.Contains(methods, elem.Method) {
+				assert.NoError(t, elem.Error, name, elem.Method)
+			} else if assert.ErrorAs(t, elem.Error, &rpcErr, name, elem.Method) {
+				assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.ErrorCode(), name, elem.Method)
+			}
+		}
+
+		rpcClient.Close()
+	}
+
+	// The discovery document isn't shadowed by the key routes
+	for _, tc := range []struct {
+		url    string
+		status int
+	}{
+		{"http://" + addr + "/openrpc.json?apikey=bob-key", http.StatusOK},
+		{"http://" + addr + "/openrpc.json", http.StatusUnauthorized},
+	} {
+		resp, err := http.Get(tc.url)
+		if err != nil {
+			t.Fatalf("failed to get discovery document: %v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, tc.url)
+	}
+
+	// Rejections don't say why
+	status, response := postRequest(t, "http://"+addr+"/?apikey=eve-key", nil, "test_whoami")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	if assert.NotNil(t, response.Error) {
+		assert.Equal(t, "unauthorized", response.Error.Message)
+		assert.Nil(t, response.Error.Data)
+	}
+}
+
+func TestServer_AuthWebsocket(t *testing.T) {
+	t.Parallel()
+
+	s := newAuthTestServer(t, &AuthConfig{
+		JWTSecret: hex.EncodeToString(testJWTSecret),
+		APIKeys: []APIKeyConfig{
+			{Name: "bob", Key: "bob-key", Methods: []string{"test_whoami"}},
+		},
+	}, nil)
+	url := "ws://" + s.Addr().String()
+
+	// Rejected at upgrade
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Authenticated once, every message is served to the same principal
+	rpcClient, err := gethrpc.Dial(url + "/?apikey=bob-key")
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	for i := 0; i < 3; i++ {
+		var result string
+		assert.NoError(t, rpcClient.Call(&result, "test_whoami"))
+		assert.Equal(t, "bob", result)
+	}
+
+	var rpcErr gethrpc.Error
+	if assert.ErrorAs(t, rpcClient.Call(nil, "test_echo", "hello"), &rpcErr) {
+		assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.ErrorCode())
+	}
+
+	// The modules only report what the key can call
+	var modules map[string]string
+	assert.NoError(t, rpcClient.Call(&modules, "rpc_modules"))
+	assert.Equal(t, map[string]string{"rpc": "1.0", "test": "1.0"}, modules)
+
+	// JWT
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+newTestJWT(t, testJWTSecret, jwt.MapClaims{"iat": time.Now().Unix()}))
+
+	jwtClient, err := dialWithHeader(url, header)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer jwtClient.Close()
+
+	var result string
+	assert.NoError(t, jwtClient.Call(&result, "admin_secret"))
+	assert.Equal(t, "secret", result)
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  *AuthConfig
+		err  error
+	}{
+		{"no credentials", &AuthConfig{}, ErrNoCredentials},
+		{"short secret", &AuthConfig{JWTSecret: "0x0102"}, ErrInvalidJWTSecret},
+		{"invalid secret", &AuthConfig{JWTSecret: strings.Repeat("z", 64)}, ErrInvalidJWTSecret},
+		{"empty key", &AuthConfig{APIKeys: []APIKeyConfig{{Name: "alice"}}}, ErrInvalidAPIKey},
+		{"duplicate key", &AuthConfig{APIKeys: []APIKeyConfig{{Name: "alice", Key: "key"}, {Name: "bob", Key: "key"}}}, ErrInvalidAPIKey},
+		{"valid", &AuthConfig{JWTSecret: hex.EncodeToString(testJWTSecret)}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newAuthenticator(tc.cfg)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+)
+
+// handleBatchRequest decodes a batch of requests and dispatches each entry through handleJsonRpcRequest.
+// Entries are served concurrently, responses keep the order of the requests.
+//
+// If the batch as a whole can't be served (malformed JSON, empty or too large), a single error response
+// is returned instead and the batch response is nil.
+func (s *Server) handleBatchRequest(ctx context.Context, message []byte) (jsonrpc.JsonRpcBatchResponse, *jsonrpc.JsonRpcResponse) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(message, &entries); err != nil {
+		return nil, jsonrpc.NewJsonRpcErrorResponse(jsonrpc.ParseError, "invalid request", err.Error(), nil)
+	}
+
+	if len(entries) == 0 {
+		return nil, jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidRequest, "invalid request", jsonrpc.ErrEmptyBatch.Error(), nil)
+	}
+
+	if len(entries) > s.cfg.maxBatchSize() {
+		return nil, jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidRequest, "invalid request", jsonrpc.ErrBatchTooLarge.Error(), nil)
+	}
+
+	var (
+		responses = make([]*jsonrpc.JsonRpcResponse, len(entries))
+		wg        sync.WaitGroup
+	)
+
+	for i, entry := range entries {
+		var request jsonrpc.JsonRpcRequest
+		if err := json.Unmarshal(entry, &request); err != nil {
+			responses[i] = jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidRequest, "invalid request", err.Error(), nil)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = s.handleJsonRpcRequest(ctx, &request)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Drop the entries with nothing to send back
+	batch := make(jsonrpc.JsonRpcBatchResponse, 0, len(responses))
+	for _, response := range responses {
+		if response != nil {
+			batch = append(batch, response)
+		}
+	}
+
+	return batch, nil
+}
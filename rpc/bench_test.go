@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/FastLane-Labs/fastlane-json-rpc/testutils"
+	gethlog "github.com/ethereum/go-ethereum/log"
+	"github.com/gorilla/websocket"
+)
+
+var benchRequest = []byte(`{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false],"id":1}`)
+
+func newBenchServer(b *testing.B) *Server {
+	// NewServer replaces the default logger, the previous one is restored once the benchmark is done
+	root := gethlog.Root()
+	b.Cleanup(func() {
+		gethlog.SetDefault(root)
+	})
+
+	s := newTestServer(b, &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}, testutils.NewMockRpcAdapter())
+
+	// Request logs would dominate the measurements
+	gethlog.SetDefault(gethlog.NewLogger(gethlog.DiscardHandler()))
+
+	return s
+}
+
+// BenchmarkServer_HandleJsonRpcRequest compares serving a request with the signature of its method
+// resolved at startup, to resolving it on every request.
+func BenchmarkServer_HandleJsonRpcRequest(b *testing.B) {
+	s := &Server{
+		cfg:     &RpcConfig{},
+		metrics: NewRpcMetrics(nil),
+		api:     testutils.NewMockRpcAdapter(),
+	}
+	s.resolveCallbacks()
+
+	root := gethlog.Root()
+	gethlog.SetDefault(gethlog.NewLogger(gethlog.DiscardHandler()))
+	b.Cleanup(func() {
+		gethlog.SetDefault(root)
+	})
+
+	serve := func(b *testing.B, uncached bool) {
+		ctx := context.Background()
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if uncached {
+				s.callbacks.Delete("mock_methodA")
+			}
+
+			var request jsonrpc.JsonRpcRequest
+			if err := json.Unmarshal(benchRequest, &request); err != nil {
+				b.Fatal(err)
+			}
+
+			if response := s.handleJsonRpcRequest(ctx, &request); !response.IsSuccess() {
+				b.Fatal(response.Error)
+			}
+		}
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		serve(b, false)
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		serve(b, true)
+	})
+}
+
+// BenchmarkServer_Http compares serving requests over HTTP with the signature of their method resolved at
+// startup, to resolving it on every request.
+func BenchmarkServer_Http(b *testing.B) {
+	s := newBenchServer(b)
+
+	client := &http.Client{}
+
+	serve := func(b *testing.B, uncached bool) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if uncached {
+				s.callbacks.Delete("mock_methodA")
+			}
+
+			resp, err := client.Post("http://"+s.Addr().String(), "application/json", bytes.NewReader(benchRequest))
+			if err != nil {
+				b.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		serve(b, false)
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		serve(b, true)
+	})
+}
+
+// BenchmarkServer_Websocket compares serving requests over websocket with the signature of their method
+// resolved at startup, to resolving it on every request.
+func BenchmarkServer_Websocket(b *testing.B) {
+	s := newBenchServer(b)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.Addr().String(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	serve := func(b *testing.B, uncached bool) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if uncached {
+				s.callbacks.Delete("mock_methodA")
+			}
+
+			if err := conn.WriteMessage(websocket.TextMessage, benchRequest); err != nil {
+				b.Fatal(err)
+			}
+			if _, _, err := conn.ReadMessage(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		serve(b, false)
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		serve(b, true)
+	})
+}
@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+
+	subscriptionType = reflect.TypeOf((*Subscription)(nil))
+)
+
+// callback is a method served by the server along with its signature, validated when it is created.
+type callback struct {
+	fn          reflect.Value
+	argTypes    []reflect.Type // Types of the arguments decoded from the params, context excluded
+	hasCtx      bool           // Whether the first argument is a context.Context
+	hasOptional bool           // Whether the last argument is an optional input, see hasOptionalInput
+	hasErr      bool           // Whether the last return value is an error
+	paramNames  []string       // Names of the arguments, used to map by-name params
+
+	// Whether the only return value of a method that returns no error is sent as the result. Methods of
+	// reflection based APIs are answered with "" instead, as they always have been
+	singleResult bool
+
+	// Whether the method returns a *Subscription, it is then only called through the subscribe method of
+	// its namespace
+	subscription bool
+
+	// Documentation of the method, included in the OpenRPC document
+	description       string
+	paramDescriptions []string
+}
+
+func newCallback(fn reflect.Value) (*callback, error) {
+	if fn.Kind() != reflect.Func {
+		return nil, errors.New("not a function")
+	}
+
+	fnType := fn.Type()
+	if fnType.IsVariadic() {
+		return nil, errors.New("variadic functions are not supported")
+	}
+
+	cb := &callback{fn: fn}
+
+	for i := 0; i < fnType.NumIn(); i++ {
+		argType := fnType.In(i)
+
+		if argType == contextType {
+			if i != 0 {
+				return nil, errors.New("context.Context must be the first argument")
+			}
+			cb.hasCtx = true
+			continue
+		}
+
+		if !isJsonType(argType) {
+			return nil, fmt.Errorf("argument %d of type %s can't be decoded from JSON", i, argType)
+		}
+		cb.argTypes = append(cb.argTypes, argType)
+	}
+
+	cb.hasOptional = hasOptionalInput(cb.argTypes)
+
+	numOut := fnType.NumOut()
+	for i := 0; i < numOut; i++ {
+		outType := fnType.Out(i)
+
+		if outType == errorType {
+			if i != numOut-1 {
+				return nil, errors.New("error must be the last return value")
+			}
+			cb.hasErr = true
+			continue
+		}
+
+		if !isJsonType(outType) {
+			return nil, fmt.Errorf("return value %d of type %s can't be encoded to JSON", i, outType)
+		}
+
+		if outType == subscriptionType {
+			cb.subscription = true
+		}
+	}
+
+	return cb, nil
+}
+
+// omitsResult reports whether the method is answered with "" although it returns a value, which is the case
+// of reflection based methods returning a single value other than error or *Subscription.
+func (cb *callback) omitsResult() bool {
+	return cb.fn.Type().NumOut() == 1 && !cb.hasErr && !cb.subscription && !cb.singleResult
+}
+
+func (cb *callback) setParamNames(names []string) error {
+	if names != nil && len(names) != len(cb.argTypes) {
+		return fmt.Errorf("method expects %d params, %d names given", len(cb.argTypes), len(names))
+	}
+	cb.paramNames = names
+	return nil
+}
+
+func (cb *callback) setParamDescriptions(descriptions []string) error {
+	if len(descriptions) != len(cb.argTypes) {
+		return fmt.Errorf("method expects %d params, %d descriptions given", len(cb.argTypes), len(descriptions))
+	}
+	cb.paramDescriptions = descriptions
+	return nil
+}
+
+// hasOptionalInput checks if the API method has defined an optional final input:
+//  1. The input must start with the "optional_" prefix in its name.
+//  2. The input must be of kind Map.
+func hasOptionalInput(argTypes []reflect.Type) bool {
+	return len(argTypes) > 0 &&
+		strings.HasPrefix(argTypes[len(argTypes)-1].Name(), optionalTypePrefix) &&
+		argTypes[len(argTypes)-1].Kind() == reflect.Map
+}
+
+func isJsonType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return false
+	}
+	return true
+}
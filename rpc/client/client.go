@@ -0,0 +1,301 @@
+// Package client is a JSON-RPC client for servers built with the rpc package, or any JSON-RPC 2.0
+// server. Requests and responses are built with the jsonrpc types, errors returned by the server are
+// returned as *jsonrpc.JsonRpcError, preserving their code and data.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultReconnectMinBackoff = 100 * time.Millisecond
+	defaultReconnectMaxBackoff = 30 * time.Second
+)
+
+var (
+	ErrClientClosed             = errors.New("client closed")
+	ErrConnectionLost           = errors.New("connection lost")
+	ErrNotificationsUnsupported = errors.New("subscriptions are only supported over websocket")
+	ErrUnsupportedScheme        = errors.New("unsupported url scheme")
+	ErrMissingResponse          = errors.New("missing response")
+)
+
+// Client sends JSON-RPC requests over HTTP or websocket, depending on the scheme of its url.
+type Client struct {
+	transport transport
+	timeout   time.Duration
+	nextId    atomic.Uint64
+}
+
+// transport sends encoded messages to the server.
+type transport interface {
+	// roundTrip sends msg and waits for the responses to the requests with the given ids, in any order.
+	// Notifications have no id, roundTrip returns once they are sent.
+	roundTrip(ctx context.Context, msg []byte, ids []string) ([]*message, error)
+	close()
+}
+
+// message is a response or a notification received from the server.
+type message struct {
+	Version string                `json:"jsonrpc"`
+	Id      jsonrpc.JsonRpcId     `json:"id,omitempty"`
+	Method  string                `json:"method,omitempty"`
+	Params  json.RawMessage       `json:"params,omitempty"`
+	Result  json.RawMessage       `json:"result,omitempty"`
+	Error   *jsonrpc.JsonRpcError `json:"error,omitempty"`
+}
+
+type options struct {
+	httpClient          *http.Client
+	dialer              *websocket.Dialer
+	header              http.Header
+	timeout             time.Duration
+	reconnect           bool
+	reconnectMinBackoff time.Duration
+	reconnectMaxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(o *options)
+
+// WithHTTPClient sets the client used to send HTTP requests.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// WithWebsocketDialer sets the dialer used to open websocket connections.
+func WithWebsocketDialer(d *websocket.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithHeader adds a header to the HTTP requests and websocket handshakes.
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		o.header.Add(key, value)
+	}
+}
+
+// WithTimeout sets the timeout of the calls made with a context without deadline.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithReconnectBackoff sets the bounds of the exponential backoff between websocket reconnection attempts.
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		o.reconnectMinBackoff = min
+		o.reconnectMaxBackoff = max
+	}
+}
+
+// WithoutReconnect disables websocket reconnection, the client is unusable once its connection is lost.
+func WithoutReconnect() Option {
+	return func(o *options) {
+		o.reconnect = false
+	}
+}
+
+// Dial creates a client for the server at rawUrl. Websocket clients (ws:// and wss://) connect right away
+// and reconnect when the connection is lost, HTTP clients (http:// and https://) send each request
+// separately.
+func Dial(ctx context.Context, rawUrl string, opts ...Option) (*Client, error) {
+	o := &options{
+		httpClient:          http.DefaultClient,
+		dialer:              websocket.DefaultDialer,
+		header:              make(http.Header),
+		reconnect:           true,
+		reconnectMinBackoff: defaultReconnectMinBackoff,
+		reconnectMaxBackoff: defaultReconnectMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{timeout: o.timeout}
+
+	switch u.Scheme {
+	case "http", "https":
+		c.transport = newHttpTransport(rawUrl, o)
+	case "ws", "wss":
+		c.transport, err = dialWebsocketTransport(ctx, rawUrl, o)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, u.Scheme)
+	}
+
+	return c, nil
+}
+
+// Close closes the connection of the client, ending its subscriptions.
+func (c *Client) Close() {
+	c.transport.close()
+}
+
+// newRequest builds a request with a new id.
+func (c *Client) newRequest(method string, params ...interface{}) (*jsonrpc.JsonRpcRequest, error) {
+	id := jsonrpc.JsonRpcId(strconv.FormatUint(c.nextId.Add(1), 10))
+	return jsonrpc.NewJsonRpcRequest(id, method, params...)
+}
+
+// withTimeout applies the client timeout to ctx if it has no deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// Call calls method with the given params and decodes its result into result, unless result is nil.
+// An error returned by the server is a *jsonrpc.JsonRpcError.
+func (c *Client) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	response, err := c.call(ctx, method, params...)
+	if err != nil {
+		return err
+	}
+
+	return decodeResult(response, result)
+}
+
+func (c *Client) call(ctx context.Context, method string, params ...interface{}) (*message, error) {
+	request, err := c.newRequest(method, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	responses, err := c.transport.roundTrip(ctx, msg, []string{request.Id.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	// HTTP servers may answer anything, e.g. an empty batch or another request's response
+	if len(responses) != 1 || responses[0] == nil || responses[0].Id.String() != request.Id.String() {
+		return nil, ErrMissingResponse
+	}
+
+	return responses[0], nil
+}
+
+// Notify sends a notification, the server doesn't answer it.
+func (c *Client) Notify(ctx context.Context, method string, params ...interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	request, err := jsonrpc.NewJsonRpcRequest(nil, method, params...)
+	if err != nil {
+		return err
+	}
+
+	msg, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.transport.roundTrip(ctx, msg, nil)
+	return err
+}
+
+// BatchElem is a request of a batch. Once the batch is sent, either Result holds the decoded result or
+// Error holds the error of the request.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Error  error
+}
+
+// BatchCall sends the requests in a single batch. The returned error is the error sending the batch,
+// errors of the requests are set in each element.
+func (c *Client) BatchCall(ctx context.Context, batch []BatchElem) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var (
+		requests = make([]*jsonrpc.JsonRpcRequest, len(batch))
+		ids      = make([]string, len(batch))
+		byId     = make(map[string]*BatchElem, len(batch))
+	)
+
+	for i := range batch {
+		request, err := c.newRequest(batch[i].Method, batch[i].Params...)
+		if err != nil {
+			return err
+		}
+
+		requests[i] = request
+		ids[i] = request.Id.String()
+		byId[ids[i]] = &batch[i]
+	}
+
+	msg, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+
+	responses, err := c.transport.roundTrip(ctx, msg, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, response := range responses {
+		if response == nil {
+			continue
+		}
+
+		if elem, ok := byId[response.Id.String()]; ok {
+			elem.Error = decodeResult(response, elem.Result)
+			delete(byId, response.Id.String())
+		}
+	}
+
+	for _, elem := range byId {
+		elem.Error = ErrMissingResponse
+	}
+
+	return nil
+}
+
+// decodeResult returns the error of the response, or decodes its result into result.
+func decodeResult(response *message, result interface{}) error {
+	if response.Error != nil {
+		return response.Error
+	}
+
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
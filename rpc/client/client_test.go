@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc"
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/FastLane-Labs/fastlane-json-rpc/testutils"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestConfig() *rpc.RpcConfig {
+	return &rpc.RpcConfig{
+		Host: "127.0.0.1",
+		HTTP: &rpc.HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &rpc.WebsocketConfig{
+			Enabled: true,
+		},
+	}
+}
+
+func TestClient_Call(t *testing.T) {
+	var (
+		notified = make(chan string, 1)
+		api      = testutils.NewMockRpcAdapter()
+	)
+
+	registry := rpc.NewRegistry()
+	assert.NoError(t, registry.Register("mock_methodA", api.Mock_methodA))
+	assert.NoError(t, registry.Register("mock_methodC", api.Mock_methodC))
+	assert.NoError(t, registry.Register("mock_methodG", api.Mock_methodG))
+	assert.NoError(t, registry.Register("test_notify", func(value string) {
+		notified <- value
+	}))
+	assert.NoError(t, registry.Register("test_sleep", func(ctx context.Context, ms int) error {
+		select {
+		case <-time.After(time.Duration(ms) * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}))
+
+	s, err := rpc.NewServer(newTestConfig(), registry, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	defer s.Close()
+
+	for _, url := range []string{"http://" + s.Addr().String(), "ws://" + s.Addr().String()} {
+		ctx := context.Background()
+
+		c, err := Dial(ctx, url, WithTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		// Result
+		var result string
+		assert.NoError(t, c.Call(ctx, &result, "mock_methodA", 1, false), url)
+		assert.Equal(t, "mock_methodA success", result, url)
+
+		// Errors preserve their code and data
+		err = c.Call(ctx, nil, "mock_methodG", "revert")
+
+		var rpcErr *jsonrpc.JsonRpcError
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.ExecutionReverted, rpcErr.Code, url)
+			assert.Equal(t, "0x08c379a0", rpcErr.Data, url)
+		}
+
+		err = c.Call(ctx, nil, "mock_unknown")
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.Code, url)
+		}
+
+		// Batch
+		batch := []BatchElem{
+			{Method: "mock_methodA", Params: []interface{}{1, false}, Result: new(string)},
+			{Method: "mock_methodA", Params: []interface{}{1, true}, Result: new(string)},
+			{Method: "mock_methodC", Params: []interface{}{[]string{"a", "b"}, false}, Result: new([]interface{})},
+		}
+		assert.NoError(t, c.BatchCall(ctx, batch), url)
+		assert.NoError(t, batch[0].Error, url)
+		assert.Equal(t, "mock_methodA success", *batch[0].Result.(*string), url)
+		assert.Error(t, batch[1].Error, url)
+		assert.NoError(t, batch[2].Error, url)
+		assert.Len(t, *batch[2].Result.(*[]interface{}), 3, url)
+
+		// Notification
+		assert.NoError(t, c.Notify(ctx, "test_notify", url), url)
+		select {
+		case value := <-notified:
+			assert.Equal(t, url, value)
+		case <-time.After(time.Second):
+			t.Fatalf("%s: notification not received", url)
+		}
+
+		// Per-call timeout
+		callCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		err = c.Call(callCtx, nil, "test_sleep", 500)
+		cancel()
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), url, err)
+
+		// Client timeout
+		err = c.Call(ctx, nil, "test_sleep", 2000)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), url, err)
+
+		// Subscriptions require websockets
+		if url[:4] == "http" {
+			_, err = c.Subscribe(ctx, "test", make(chan int), "ticks")
+			assert.ErrorIs(t, err, ErrNotificationsUnsupported)
+		}
+
+		c.Close()
+	}
+}
+
+func TestClient_HttpErrors(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.RateLimit = &rpc.RateLimitConfig{
+		Enabled: true,
+		Rate:    0.01,
+		Burst:   1,
+	}
+
+	s, err := rpc.NewServer(cfg, testutils.NewMockRpcAdapter(), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	defer s.Close()
+
+	ctx := context.Background()
+
+	c, err := Dial(ctx, "http://"+s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer c.Close()
+
+	// JSON-RPC errors sent with an error status are returned as such
+	var result string
+	assert.NoError(t, c.Call(ctx, &result, "mock_methodA", 1, false))
+
+	var rpcErr *jsonrpc.JsonRpcError
+	if assert.ErrorAs(t, c.Call(ctx, &result, "mock_methodA", 1, false), &rpcErr) {
+		assert.Equal(t, jsonrpc.LimitExceeded, rpcErr.Code)
+	}
+
+	// Other error statuses are HTTP errors
+	c2, err := Dial(ctx, "http://"+s.Addr().String()+"/unknown/path")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer c2.Close()
+
+	var httpErr *HTTPError
+	if assert.ErrorAs(t, c2.Call(ctx, &result, "mock_methodA", 1, false), &httpErr) {
+		assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+	}
+}
+
+func TestClient_HttpMissingResponse(t *testing.T) {
+	body := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(<-body))
+	}))
+
+	defer server.Close()
+
+	ctx := context.Background()
+
+	c, err := Dial(ctx, server.URL, WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer c.Close()
+
+	for _, answer := range []string{
+		`[]`,
+		`[null]`,
+		`{"jsonrpc":"2.0","result":"0x1","id":12345}`,
+		`[{"jsonrpc":"2.0","result":"0x1","id":1},{"jsonrpc":"2.0","result":"0x2","id":2}]`,
+	} {
+		body <- answer
+
+		var result string
+		assert.ErrorIs(t, c.Call(ctx, &result, "test_method"), ErrMissingResponse, answer)
+	}
+}
+
+func TestClient_WebsocketErrorWithoutId(t *testing.T) {
+	// The server can't read any message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid request"},"id":null}`))
+		}
+	}))
+
+	defer server.Close()
+
+	ctx := context.Background()
+
+	c, err := Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer c.Close()
+
+	// The error is returned to the pending call instead of timing out
+	var rpcErr *jsonrpc.JsonRpcError
+	if assert.ErrorAs(t, c.Call(ctx, nil, "mock_methodA", 1, false), &rpcErr) {
+		assert.Equal(t, jsonrpc.ParseError, rpcErr.Code)
+	}
+
+	batch := []BatchElem{
+		{Method: "mock_methodA", Params: []interface{}{1, false}},
+		{Method: "mock_methodA", Params: []interface{}{2, false}},
+	}
+	if assert.ErrorAs(t, c.BatchCall(ctx, batch), &rpcErr) {
+		assert.Equal(t, jsonrpc.ParseError, rpcErr.Code)
+	}
+}
+
+type tickerApi struct{}
+
+func (tickerApi) Ticks(ctx context.Context, from int) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for i := from; ; i++ {
+			select {
+			case <-ticker.C:
+				if err := notifier.Notify(sub.ID, i); err != nil {
+					return
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+func TestClient_SubscriptionReconnect(t *testing.T) {
+	registry := rpc.NewRegistry()
+	assert.NoError(t, registry.RegisterNamespace("test", tickerApi{}))
+
+	s, err := rpc.NewServer(newTestConfig(), registry, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	addr := s.Addr().String()
+
+	ctx := context.Background()
+
+	c, err := Dial(ctx, "ws://"+addr, WithReconnectBackoff(10*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer c.Close()
+
+	ticks := make(chan int)
+	sub, err := c.Subscribe(ctx, "test", ticks, "ticks", 100)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	receive := func() int {
+		select {
+		case tick := <-ticks:
+			return tick
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for notification")
+		}
+		return 0
+	}
+
+	assert.Equal(t, 100, receive())
+	assert.Equal(t, 101, receive())
+
+	// Restart the server on the same address
+	s.Close()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s, err = rpc.NewServerWithListener(newTestConfig(), ln, registry, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	defer s.Close()
+
+	// The subscription is opened again with the same params
+	assert.Eventually(t, func() bool {
+		return receive() == 100
+	}, 2*time.Second, time.Millisecond)
+
+	// Calls go through the new connection
+	var result interface{}
+	err = c.Call(ctx, &result, "test_unknown")
+	var rpcErr *jsonrpc.JsonRpcError
+	assert.ErrorAs(t, err, &rpcErr)
+
+	sub.Unsubscribe()
+
+	_, ok := <-sub.Err()
+	assert.False(t, ok)
+}
+
+func TestClient_WithoutReconnect(t *testing.T) {
+	registry := rpc.NewRegistry()
+	assert.NoError(t, registry.RegisterNamespace("test", tickerApi{}))
+
+	s, err := rpc.NewServer(newTestConfig(), registry, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+
+	c, err := Dial(ctx, "ws://"+s.Addr().String(), WithoutReconnect())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer c.Close()
+
+	sub, err := c.Subscribe(ctx, "test", make(chan int, 1000), "ticks", 0)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	s.Close()
+
+	select {
+	case err := <-sub.Err():
+		assert.ErrorIs(t, err, ErrConnectionLost)
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not ended")
+	}
+
+	assert.ErrorIs(t, c.Call(ctx, nil, "test_unknown"), ErrConnectionLost)
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+)
+
+// HTTPError is returned when the server answers with an HTTP error status and no JSON-RPC response.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	if len(e.Body) == 0 {
+		return e.Status
+	}
+	return fmt.Sprintf("%s: %s", e.Status, e.Body)
+}
+
+type httpTransport struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+func newHttpTransport(url string, o *options) *httpTransport {
+	return &httpTransport{
+		url:    url,
+		client: o.httpClient,
+		header: o.header,
+	}
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, msg []byte, ids []string) ([]*message, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range t.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 && resp.StatusCode < http.StatusBadRequest {
+		return nil, nil
+	}
+
+	// Error statuses may come with JSON-RPC responses (e.g. 429 when rate limited), which are returned as such
+	responses, err := decodeMessages(body)
+	if resp.StatusCode >= http.StatusBadRequest && (err != nil || !isJsonRpc(responses)) {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A single error without id answers a request or batch the server couldn't read
+	if len(responses) == 1 && responses[0] != nil && responses[0].Error != nil && (responses[0].Id.IsAbsent() || responses[0].Id.IsNull()) {
+		return nil, responses[0].Error
+	}
+
+	return responses, nil
+}
+
+func (t *httpTransport) close() {}
+
+// isJsonRpc reports whether msgs are all JSON-RPC 2.0 messages, as opposed to other JSON documents.
+func isJsonRpc(msgs []*message) bool {
+	for _, msg := range msgs {
+		if msg == nil || msg.Version != "2.0" {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeMessages decodes a single message or a batch of messages.
+func decodeMessages(raw []byte) ([]*message, error) {
+	if jsonrpc.IsBatch(raw) {
+		var msgs []*message
+		if err := json.Unmarshal(raw, &msgs); err != nil {
+			return nil, err
+		}
+		return msgs, nil
+	}
+
+	var msg message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, err
+	}
+
+	return []*message{&msg}, nil
+}
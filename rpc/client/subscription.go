@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	subscribeMethodSuffix   = "_subscribe"
+	unsubscribeMethodSuffix = "_unsubscribe"
+
+	// Notifications buffered while the subscriber is not receiving
+	subscriptionQueueSize = 1000
+
+	unsubscribeTimeout = 5 * time.Second
+)
+
+var (
+	ErrInvalidChannel            = errors.New("channel must be a writable channel")
+	ErrSubscriptionQueueOverflow = errors.New("subscription queue overflow")
+	ErrInvalidSubscriptionId     = errors.New("invalid subscription id")
+)
+
+// Subscription receives the notifications of a subscription opened with Subscribe. The subscription
+// is opened again with the same params when the client reconnects.
+type Subscription struct {
+	client    *Client
+	transport *wsTransport
+	namespace string
+	params    []interface{}
+	channel   reflect.Value
+
+	// Id given by the server, guarded by the transport lock
+	id string
+
+	in      chan json.RawMessage
+	err     chan error
+	quit    chan struct{}
+	endOnce sync.Once
+}
+
+// Subscribe opens the subscription <namespace>_subscribe with the given params, the first one being
+// usually the subscription name, and sends the notifications to channel, which must be a writable
+// channel of a type the notification results can be decoded into.
+//
+// Subscriptions are only supported over websocket.
+func (c *Client) Subscribe(ctx context.Context, namespace string, channel interface{}, params ...interface{}) (*Subscription, error) {
+	t, ok := c.transport.(*wsTransport)
+	if !ok {
+		return nil, ErrNotificationsUnsupported
+	}
+
+	chanVal := reflect.ValueOf(channel)
+	if chanVal.Kind() != reflect.Chan || chanVal.Type().ChanDir()&reflect.SendDir == 0 {
+		return nil, ErrInvalidChannel
+	}
+
+	sub := &Subscription{
+		client:    c,
+		transport: t,
+		namespace: namespace,
+		params:    params,
+		channel:   chanVal,
+		in:        make(chan json.RawMessage, subscriptionQueueSize),
+		err:       make(chan error, 1),
+		quit:      make(chan struct{}),
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if err := sub.subscribe(ctx); err != nil {
+		return nil, err
+	}
+
+	go sub.forward()
+
+	return sub, nil
+}
+
+// subscribe sends the subscribe request, the subscription is registered by the read loop on success.
+func (s *Subscription) subscribe(ctx context.Context) error {
+	request, err := s.client.newRequest(s.namespace+subscribeMethodSuffix, s.params...)
+	if err != nil {
+		return err
+	}
+
+	msg, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	responses, err := s.transport.send(ctx, msg, []string{request.Id.String()}, s)
+	if err != nil {
+		return err
+	}
+
+	if responses[0].Error != nil {
+		return responses[0].Error
+	}
+
+	s.transport.mu.Lock()
+	defer s.transport.mu.Unlock()
+
+	if s.id == "" {
+		return ErrInvalidSubscriptionId
+	}
+
+	return nil
+}
+
+// deliver queues a notification, the subscription fails if the subscriber doesn't keep up.
+func (s *Subscription) deliver(result json.RawMessage) {
+	select {
+	case s.in <- result:
+	default:
+		if s.end(ErrSubscriptionQueueOverflow) {
+			go s.unsubscribe()
+		}
+	}
+}
+
+// forward decodes the queued notifications and sends them to the channel of the subscriber.
+func (s *Subscription) forward() {
+	for {
+		select {
+		case <-s.quit:
+			return
+		case raw := <-s.in:
+			value := reflect.New(s.channel.Type().Elem())
+			if err := json.Unmarshal(raw, value.Interface()); err != nil {
+				if s.end(err) {
+					go s.unsubscribe()
+				}
+				return
+			}
+
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.quit)},
+				{Dir: reflect.SelectSend, Chan: s.channel, Send: value.Elem()},
+			})
+			if chosen == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Err returns a channel receiving the error ending the subscription. It is closed when the
+// subscription is unsubscribed or the client is closed.
+func (s *Subscription) Err() <-chan error {
+	return s.err
+}
+
+// Unsubscribe cancels the subscription on the server and closes the Err channel.
+func (s *Subscription) Unsubscribe() {
+	if s.end(nil) {
+		s.unsubscribe()
+	}
+}
+
+// unsubscribe cancels the subscription on the server.
+func (s *Subscription) unsubscribe() {
+	s.transport.mu.Lock()
+	id := s.id
+	s.transport.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), unsubscribeTimeout)
+	defer cancel()
+
+	s.client.Call(ctx, nil, s.namespace+unsubscribeMethodSuffix, id)
+}
+
+func (s *Subscription) ended() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// end stops the subscription, err is sent to the subscriber unless nil. It reports whether the
+// subscription was still active.
+func (s *Subscription) end(err error) bool {
+	ended := false
+
+	s.endOnce.Do(func() {
+		ended = true
+
+		s.transport.removeSubscription(s)
+
+		if err != nil {
+			s.err <- err
+		}
+		close(s.err)
+		close(s.quit)
+	})
+
+	return ended
+}
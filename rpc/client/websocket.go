@@ -0,0 +1,404 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	notificationMethodSuffix = "_subscription"
+
+	resubscribeTimeout = 10 * time.Second
+)
+
+// wsConn is a websocket connection of the transport, lost is closed when its read loop ends.
+type wsConn struct {
+	*websocket.Conn
+	lost chan struct{}
+}
+
+// pendingCall waits for the responses to the requests sent on conn.
+type pendingCall struct {
+	conn      *wsConn
+	seq       uint64 // order in which the calls were sent
+	responses chan *message
+
+	// Registered by the read loop when the subscribe request succeeds, before reading further messages
+	sub *Subscription
+}
+
+type wsTransport struct {
+	url    string
+	dialer *websocket.Dialer
+	header http.Header
+
+	reconnect           bool
+	reconnectMinBackoff time.Duration
+	reconnectMaxBackoff time.Duration
+
+	mu        sync.Mutex
+	conn      *wsConn       // nil while disconnected
+	connected chan struct{} // closed once conn is set
+	err       error         // set once the transport is unusable
+	pending   map[string]*pendingCall
+	nextSeq   uint64
+	subs      map[string]*Subscription // by subscription id
+	closeChan chan struct{}
+
+	writeMu sync.Mutex
+}
+
+func dialWebsocketTransport(ctx context.Context, url string, o *options) (*wsTransport, error) {
+	t := &wsTransport{
+		url:                 url,
+		dialer:              o.dialer,
+		header:              o.header,
+		reconnect:           o.reconnect,
+		reconnectMinBackoff: o.reconnectMinBackoff,
+		reconnectMaxBackoff: o.reconnectMaxBackoff,
+		connected:           make(chan struct{}),
+		pending:             make(map[string]*pendingCall),
+		subs:                make(map[string]*Subscription),
+		closeChan:           make(chan struct{}),
+	}
+
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.setConn(conn)
+
+	return t, nil
+}
+
+func (t *wsTransport) dial(ctx context.Context) (*wsConn, error) {
+	conn, resp, err := t.dialer.DialContext(ctx, t.url, t.header)
+	if err != nil {
+		if resp != nil {
+			return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return nil, err
+	}
+
+	return &wsConn{Conn: conn, lost: make(chan struct{})}, nil
+}
+
+// setConn starts using conn, it reports false and closes conn if the transport was closed meanwhile.
+func (t *wsTransport) setConn(conn *wsConn) bool {
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		conn.Close()
+		return false
+	}
+	t.conn = conn
+	close(t.connected)
+	t.mu.Unlock()
+
+	go t.readLoop(conn)
+	return true
+}
+
+// waitConn returns the current connection, waiting for the transport to reconnect if needed.
+func (t *wsTransport) waitConn(ctx context.Context) (*wsConn, error) {
+	for {
+		t.mu.Lock()
+		conn, connected, err := t.conn, t.connected, t.err
+		t.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-connected:
+		case <-t.closeChan:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (t *wsTransport) roundTrip(ctx context.Context, msg []byte, ids []string) ([]*message, error) {
+	return t.send(ctx, msg, ids, nil)
+}
+
+// send writes msg and waits for the responses to the requests with the given ids. If sub is set, it is
+// registered under the id returned by the server before any notification is read.
+func (t *wsTransport) send(ctx context.Context, msg []byte, ids []string, sub *Subscription) ([]*message, error) {
+	conn, err := t.waitConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	call := &pendingCall{
+		conn:      conn,
+		responses: make(chan *message, len(ids)),
+		sub:       sub,
+	}
+
+	t.mu.Lock()
+	t.nextSeq++
+	call.seq = t.nextSeq
+	for _, id := range ids {
+		t.pending[id] = call
+	}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		for _, id := range ids {
+			delete(t.pending, id)
+		}
+		t.mu.Unlock()
+	}()
+
+	t.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	} else {
+		conn.SetWriteDeadline(time.Time{})
+	}
+	err = conn.WriteMessage(websocket.TextMessage, msg)
+	t.writeMu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*message, 0, len(ids))
+	for len(responses) < len(ids) {
+		select {
+		case response := <-call.responses:
+			// A single error without id answers a request or batch the server couldn't read
+			if response.Id.IsAbsent() || response.Id.IsNull() {
+				return nil, response.Error
+			}
+			responses = append(responses, response)
+		case <-conn.lost:
+			// Responses read before the connection was lost are still delivered
+			select {
+			case response := <-call.responses:
+				responses = append(responses, response)
+			default:
+				return nil, ErrConnectionLost
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return responses, nil
+}
+
+func (t *wsTransport) readLoop(conn *wsConn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		msgs, err := decodeMessages(data)
+		if err != nil {
+			continue
+		}
+
+		for _, msg := range msgs {
+			t.dispatch(conn, msg)
+		}
+	}
+
+	conn.Close()
+
+	t.mu.Lock()
+	t.conn = nil
+	t.connected = make(chan struct{})
+	close(conn.lost)
+
+	closing := t.err != nil
+	if !closing && !t.reconnect {
+		t.err = ErrConnectionLost
+	}
+	t.mu.Unlock()
+
+	switch {
+	case closing:
+	case t.reconnect:
+		t.reconnectLoop()
+	default:
+		t.endSubscriptions(ErrConnectionLost)
+	}
+}
+
+func (t *wsTransport) dispatch(conn *wsConn, msg *message) {
+	// Subscription notification
+	if msg.Id.IsAbsent() && strings.HasSuffix(msg.Method, notificationMethodSuffix) {
+		var params jsonrpc.JsonRpcSubscriptionResult
+		var result json.RawMessage
+		params.Result = &result
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		sub, ok := t.subs[params.Subscription]
+		t.mu.Unlock()
+
+		if ok {
+			sub.deliver(result)
+		}
+		return
+	}
+
+	// Errors without id can't be matched to their request, they are delivered to the oldest call of the
+	// connection, which is the only one unless requests are sent concurrently
+	if msg.Error != nil && (msg.Id.IsAbsent() || msg.Id.IsNull()) {
+		t.mu.Lock()
+		call := t.oldestCall(conn)
+		if call != nil {
+			for id, pending := range t.pending {
+				if pending == call {
+					delete(t.pending, id)
+				}
+			}
+		}
+		t.mu.Unlock()
+
+		if call != nil {
+			call.responses <- msg
+		}
+		return
+	}
+
+	t.mu.Lock()
+	call, ok := t.pending[msg.Id.String()]
+	delete(t.pending, msg.Id.String())
+	if ok && call.sub != nil && msg.Error == nil && !call.sub.ended() {
+		var id string
+		if err := json.Unmarshal(msg.Result, &id); err == nil {
+			// The id of the previous connection is replaced when resubscribing
+			delete(t.subs, call.sub.id)
+			call.sub.id = id
+			t.subs[id] = call.sub
+		}
+	}
+	t.mu.Unlock()
+
+	if ok {
+		call.responses <- msg
+	}
+}
+
+// oldestCall returns the first call sent on conn still waiting for responses, nil if there is none. t.mu
+// must be held.
+func (t *wsTransport) oldestCall(conn *wsConn) *pendingCall {
+	var oldest *pendingCall
+	for _, call := range t.pending {
+		if call.conn == conn && (oldest == nil || call.seq < oldest.seq) {
+			oldest = call
+		}
+	}
+	return oldest
+}
+
+// reconnectLoop reconnects with an exponential backoff, then resubscribes the active subscriptions.
+func (t *wsTransport) reconnectLoop() {
+	backoff := t.reconnectMinBackoff
+
+	for {
+		select {
+		case <-t.closeChan:
+			return
+		case <-time.After(backoff):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), t.reconnectMaxBackoff)
+		conn, err := t.dial(ctx)
+		cancel()
+
+		if err == nil {
+			t.mu.Lock()
+			subs := make([]*Subscription, 0, len(t.subs))
+			for _, sub := range t.subs {
+				subs = append(subs, sub)
+			}
+			t.mu.Unlock()
+
+			if !t.setConn(conn) {
+				return
+			}
+
+			// Subscription ids are only valid for the connection they were created on
+			for _, sub := range subs {
+				go t.resubscribe(sub)
+			}
+			return
+		}
+
+		backoff *= 2
+		if backoff > t.reconnectMaxBackoff {
+			backoff = t.reconnectMaxBackoff
+		}
+	}
+}
+
+func (t *wsTransport) resubscribe(sub *Subscription) {
+	ctx, cancel := context.WithTimeout(context.Background(), resubscribeTimeout)
+	defer cancel()
+
+	if err := sub.subscribe(ctx); err != nil {
+		sub.end(err)
+	}
+}
+
+func (t *wsTransport) removeSubscription(sub *Subscription) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.subs[sub.id] == sub {
+		delete(t.subs, sub.id)
+	}
+}
+
+func (t *wsTransport) endSubscriptions(err error) {
+	t.mu.Lock()
+	subs := make([]*Subscription, 0, len(t.subs))
+	for _, sub := range t.subs {
+		subs = append(subs, sub)
+	}
+	t.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.end(err)
+	}
+}
+
+func (t *wsTransport) close() {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = ErrClientClosed
+		close(t.closeChan)
+	}
+	conn := t.conn
+	t.mu.Unlock()
+
+	if conn != nil {
+		t.writeMu.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		t.writeMu.Unlock()
+		conn.Close()
+	}
+
+	t.endSubscriptions(nil)
+}
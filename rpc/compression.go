@@ -0,0 +1,226 @@
+package rpc
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingZstd    = "zstd"
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+)
+
+// Encodings offered for responses, in order of preference
+var responseEncodings = []string{encodingZstd, encodingGzip, encodingDeflate}
+
+// resettableWriter is a compressor that can be reused for another destination.
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// Compressors are expensive to allocate, they are pooled by encoding
+var compressorPools = map[string]*sync.Pool{
+	encodingZstd: {New: func() interface{} {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+	encodingGzip: {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+	encodingDeflate: {New: func() interface{} {
+		return zlib.NewWriter(nil)
+	}},
+}
+
+// negotiateEncoding picks the preferred response encoding accepted by the client according to the
+// Accept-Encoding header, or an empty string if none is.
+func negotiateEncoding(acceptEncoding string) string {
+	var (
+		accepted = make(map[string]bool)
+		refused  = make(map[string]bool)
+		wildcard bool
+	)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if q <= 0 {
+			refused[coding] = true
+			continue
+		}
+
+		if coding == "*" {
+			wildcard = true
+		}
+		accepted[coding] = true
+	}
+
+	// A coding refused with q=0 is never picked, even if also listed or covered by the wildcard
+	for _, encoding := range responseEncodings {
+		if accepted[encoding] && !refused[encoding] {
+			return encoding
+		}
+	}
+
+	if wildcard {
+		for _, encoding := range responseEncodings {
+			if !refused[encoding] {
+				return encoding
+			}
+		}
+	}
+
+	return ""
+}
+
+// newBodyDecoder returns a reader decompressing body according to the Content-Encoding header.
+func newBodyDecoder(contentEncoding string, body io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case encodingGzip:
+		return gzip.NewReader(body)
+	case encodingDeflate:
+		return zlib.NewReader(body)
+	case encodingZstd:
+		dec, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// compressWriter compresses the response once its body reaches the threshold, smaller responses are
+// sent as is. Close must be called once the response is written.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding  string
+	threshold int
+	metrics   *RpcMetrics
+
+	status     int
+	buf        []byte
+	compressor resettableWriter
+	compressed *countingWriter
+	written    int64
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string, threshold int, metrics *RpcMetrics) *compressWriter {
+	// The response varies with Accept-Encoding, whether or not it ends up compressed
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	return &compressWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		threshold:      threshold,
+		metrics:        metrics,
+		status:         http.StatusOK,
+	}
+}
+
+// WriteHeader defers the status until it is known whether the body is compressed.
+func (w *compressWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.compressor != nil {
+		w.written += int64(len(p))
+		return w.compressor.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.threshold {
+		return len(p), nil
+	}
+
+	// Threshold reached, the buffered body is compressed
+	w.Header().Set("Content-Encoding", w.encoding)
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.compressed = &countingWriter{Writer: w.ResponseWriter}
+	w.compressor = compressorPools[w.encoding].Get().(resettableWriter)
+	w.compressor.Reset(w.compressed)
+
+	buf := w.buf
+	w.buf = nil
+	w.written = int64(len(buf))
+
+	if _, err := w.compressor.Write(buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close flushes the response, uncompressed if it stayed under the threshold.
+func (w *compressWriter) Close() error {
+	if w.compressor == nil {
+		w.ResponseWriter.WriteHeader(w.status)
+		if len(w.buf) == 0 {
+			return nil
+		}
+		_, err := w.ResponseWriter.Write(w.buf)
+		return err
+	}
+
+	err := w.compressor.Close()
+	compressorPools[w.encoding].Put(w.compressor)
+	w.compressor = nil
+
+	if w.metrics.enabled && w.written > 0 {
+		w.metrics.ResponseCompressionRatio.WithLabelValues(w.encoding).Observe(float64(w.compressed.n) / float64(w.written))
+	}
+
+	return err
+}
@@ -1,16 +1,481 @@
 package rpc
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+)
+
+const (
+	defaultMaxBatchSize    = 100
+	defaultShutdownTimeout = 10 * time.Second
+	defaultSendBufferSize  = 256
+	defaultMaxBodySize     = 5 * 1024 * 1024
+	defaultCompressionSize = 1024
+	defaultPongTimeout     = 60 * time.Second
+	defaultWriteTimeout    = 2 * time.Second
+
+	defaultDiscoveryEndpoint = "/openrpc.json"
+	defaultDiscoveryTitle    = "JSON-RPC API"
+	defaultDiscoveryVersion  = "1.0.0"
+	defaultModuleVersion     = "1.0"
+
+	defaultJWTIatSkew       = 60 * time.Second
+	defaultAPIKeyHeader     = "X-API-Key"
+	defaultAPIKeyQueryParam = "apikey"
+)
+
+var (
+	ErrInvalidSlowConsumerPolicy = errors.New("invalid slow consumer policy")
+	ErrInvalidPingInterval       = errors.New("ping interval must be shorter than pong timeout")
+	ErrInvalidRateLimit          = errors.New("invalid rate limit")
+	ErrMissingIpcPath            = errors.New("missing IPC socket path")
+)
+
+// SlowConsumerPolicy is what a websocket connection does with a message when its send buffer is full.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerBlock waits for room in the buffer, at most SlowConsumerTimeout if set, then disconnects
+	SlowConsumerBlock SlowConsumerPolicy = "block"
+	// SlowConsumerDrop drops the message
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+	// SlowConsumerDisconnect closes the connection
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// RateLimitKey is what identifies the clients sharing a rate limit bucket.
+type RateLimitKey string
+
+const (
+	// RateLimitByIP limits each IP
+	RateLimitByIP RateLimitKey = "ip"
+	// RateLimitByAPIKey limits each API key or JWT subject, unauthenticated clients are limited by IP
+	RateLimitByAPIKey RateLimitKey = "api_key"
+)
+
+// RateLimitKeyFunc returns the key identifying the client of a request, from the request context. Requests
+// with an empty key are not limited.
+type RateLimitKeyFunc func(ctx context.Context) string
+
 type RpcConfig struct {
-	Port                uint64           `mapstructure:"port"`
-	HealthcheckEndpoint string           `mapstructure:"healthcheck_endpoint"`
-	HTTP                *HttpConfig      `mapstructure:"http"`
-	Websocket           *WebsocketConfig `mapstructure:"websocket"`
+	Host                string                   `mapstructure:"host"`
+	Port                uint64                   `mapstructure:"port"`
+	HealthcheckEndpoint string                   `mapstructure:"healthcheck_endpoint"`
+	MaxBatchSize        int                      `mapstructure:"max_batch_size"`
+	ShutdownTimeout     time.Duration            `mapstructure:"shutdown_timeout"`
+	RequestTimeout      time.Duration            `mapstructure:"request_timeout"`
+	MethodTimeouts      map[string]time.Duration `mapstructure:"method_timeouts"`
+	HTTP                *HttpConfig              `mapstructure:"http"`
+	Websocket           *WebsocketConfig         `mapstructure:"websocket"`
+	IPC                 *IpcConfig               `mapstructure:"ipc"`
+	TLS                 *TLSConfig               `mapstructure:"tls"`
+	Discovery           *DiscoveryConfig         `mapstructure:"discovery"`
+	ModuleVersions      map[string]string        `mapstructure:"module_versions"`
+	Auth                *AuthConfig              `mapstructure:"auth"`
+	RateLimit           *RateLimitConfig         `mapstructure:"rate_limit"`
 }
 
+// HttpConfig configures the HTTP transport. Only the methods of the namespaces listed in Modules are served,
+// all of them if unset. Request bodies are limited to MaxBodySize bytes once decompressed, and must be sent
+// with an application/json content type when StrictContentType is set. When Compression is set, responses of
+// at least CompressionThreshold bytes are compressed with the preferred encoding accepted by the client (zstd,
+// gzip or deflate).
 type HttpConfig struct {
-	Enabled bool `mapstructure:"enabled"`
+	Enabled              bool     `mapstructure:"enabled"`
+	MaxBodySize          int64    `mapstructure:"max_body_size"`
+	StrictContentType    bool     `mapstructure:"strict_content_type"`
+	Compression          bool     `mapstructure:"compression"`
+	CompressionThreshold int      `mapstructure:"compression_threshold"`
+	Modules              []string `mapstructure:"modules"`
 }
 
+// WebsocketConfig configures the websocket transport. Only the methods of the namespaces listed in Modules are
+// served, all of them if unset. Zero limits are unlimited. Connections are pinged every PingInterval and
+// closed when no pong is received within PongTimeout. Browsers are only allowed to connect from
+// AllowedOrigins, if set ("*" allows any origin).
 type WebsocketConfig struct {
-	Enabled bool `mapstructure:"enabled"`
+	Enabled             bool               `mapstructure:"enabled"`
+	MaxConnections      int                `mapstructure:"max_connections"`
+	MaxConnectionsPerIP int                `mapstructure:"max_connections_per_ip"`
+	MaxMessageSize      int64              `mapstructure:"max_message_size"`
+	MaxInFlight         int                `mapstructure:"max_in_flight"`
+	SendBufferSize      int                `mapstructure:"send_buffer_size"`
+	SlowConsumerPolicy  SlowConsumerPolicy `mapstructure:"slow_consumer_policy"`
+	SlowConsumerTimeout time.Duration      `mapstructure:"slow_consumer_timeout"`
+	PingInterval        time.Duration      `mapstructure:"ping_interval"`
+	PongTimeout         time.Duration      `mapstructure:"pong_timeout"`
+	WriteTimeout        time.Duration      `mapstructure:"write_timeout"`
+	ReadBufferSize      int                `mapstructure:"read_buffer_size"`
+	WriteBufferSize     int                `mapstructure:"write_buffer_size"`
+	EnableCompression   bool               `mapstructure:"enable_compression"`
+	AllowedOrigins      []string           `mapstructure:"allowed_origins"`
+	Subprotocols        []string           `mapstructure:"subprotocols"`
+	Modules             []string           `mapstructure:"modules"`
+}
+
+// IpcConfig enables the IPC transport, serving JSON-RPC over the unix socket at Path. The socket is only
+// accessible to the user running the server. Only the methods of the namespaces listed in Modules are
+// served, all of them if unset.
+type IpcConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Path    string   `mapstructure:"path"`
+	Modules []string `mapstructure:"modules"`
+}
+
+// TLSConfig enables HTTPS and WSS. The certificate, key and client CA files are reloaded when modified. If a
+// client CA is set, clients must present a certificate signed by it (mutual TLS).
+type TLSConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	CertFile       string        `mapstructure:"cert_file"`
+	KeyFile        string        `mapstructure:"key_file"`
+	ClientCAFile   string        `mapstructure:"client_ca_file"`
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+}
+
+// DiscoveryConfig enables the OpenRPC document describing the served methods. It is returned by the
+// rpc.discover method and by GET requests to Endpoint. Title and Version are the info of the document.
+type DiscoveryConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Endpoint string `mapstructure:"endpoint"`
+	Title    string `mapstructure:"title"`
+	Version  string `mapstructure:"version"`
+}
+
+// AuthConfig enables the authentication of HTTP requests and websocket connections, IPC connections are
+// not authenticated. Websocket connections are authenticated once, when upgraded.
+//
+// Clients authenticate either with an HS256 JWT sent as a bearer token in the Authorization header, or with
+// one of APIKeys. The JWT secret is hex encoded, as in the Engine API jwtsecret file that can be set
+// instead with JWTSecretFile, and the iat claim of tokens must be within JWTIatSkew of the current time.
+// API keys are sent in the APIKeyHeader header, in the APIKeyQueryParam query param, or as the request
+// path ("/<key>") if APIKeyInPath is set.
+type AuthConfig struct {
+	Enabled          bool           `mapstructure:"enabled"`
+	JWTSecret        string         `mapstructure:"jwt_secret"`
+	JWTSecretFile    string         `mapstructure:"jwt_secret_file"`
+	JWTIatSkew       time.Duration  `mapstructure:"jwt_iat_skew"`
+	APIKeys          []APIKeyConfig `mapstructure:"api_keys"`
+	APIKeyHeader     string         `mapstructure:"api_key_header"`
+	APIKeyQueryParam string         `mapstructure:"api_key_query_param"`
+	APIKeyInPath     bool           `mapstructure:"api_key_in_path"`
+}
+
+// APIKeyConfig is an API key, Name identifies its holder. If set, Methods restricts the methods the key
+// can call, either by name or by namespace ("eth_*").
+type APIKeyConfig struct {
+	Name    string   `mapstructure:"name"`
+	Key     string   `mapstructure:"key"`
+	Methods []string `mapstructure:"methods"`
+}
+
+// RateLimitConfig enables token bucket rate limiting of the requests, including batch elements and websocket
+// messages. Each client has a bucket of Burst tokens, refilled at Rate tokens per second, and each request
+// takes the cost of its method in MethodCosts, 1 if unset. Subscribe requests also take the cost of the
+// subscription method they open, if set.
+//
+// Clients are identified by KeyBy: their IP, or the API key or JWT subject they authenticated with, falling
+// back to their IP. KeyFunc, if set, identifies clients instead. IPC clients have no IP and are only limited
+// by KeyFunc. Requests over the limit are rejected with a LimitExceeded error, with HTTP status 429 for single
+// HTTP requests and notifications.
+type RateLimitConfig struct {
+	Enabled     bool             `mapstructure:"enabled"`
+	Rate        float64          `mapstructure:"rate"`
+	Burst       int              `mapstructure:"burst"`
+	KeyBy       RateLimitKey     `mapstructure:"key_by"`
+	KeyFunc     RateLimitKeyFunc `mapstructure:"-"`
+	MethodCosts map[string]int   `mapstructure:"method_costs"`
+}
+
+// listenAddr returns the address the server listens on, all interfaces when Host is unset.
+func (c *RpcConfig) listenAddr() string {
+	return net.JoinHostPort(c.Host, strconv.FormatUint(c.Port, 10))
+}
+
+// maxBatchSize returns the configured maximum number of requests in a batch,
+// falling back to the default when unset.
+func (c *RpcConfig) maxBatchSize() int {
+	if c.MaxBatchSize <= 0 {
+		return defaultMaxBatchSize
+	}
+	return c.MaxBatchSize
+}
+
+// shutdownTimeout returns how long Close waits for the server to shut down, falling back to the
+// default when unset.
+func (c *RpcConfig) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return c.ShutdownTimeout
+}
+
+// methodTimeout returns the timeout applied to the given method: its entry in MethodTimeouts if any,
+// RequestTimeout otherwise. Zero means no timeout. Timeouts cancel the context of the method, they only
+// stop the methods observing it.
+func (c *RpcConfig) methodTimeout(method string) time.Duration {
+	if timeout, ok := c.MethodTimeouts[method]; ok {
+		return timeout
+	}
+	return c.RequestTimeout
+}
+
+// moduleEnabled reports whether the methods of namespace are served on transport.
+func (c *RpcConfig) moduleEnabled(transport rpcContext.Transport, namespace string) bool {
+	var modules []string
+
+	switch transport {
+	case rpcContext.TransportHTTP:
+		if c.HTTP != nil {
+			modules = c.HTTP.Modules
+		}
+	case rpcContext.TransportWebsocket:
+		if c.Websocket != nil {
+			modules = c.Websocket.Modules
+		}
+	case rpcContext.TransportIPC:
+		if c.IPC != nil {
+			modules = c.IPC.Modules
+		}
+	}
+
+	if len(modules) == 0 {
+		return true
+	}
+
+	for _, module := range modules {
+		if module == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// moduleVersion returns the version reported for namespace, falling back to the default when unset.
+func (c *RpcConfig) moduleVersion(namespace string) string {
+	if version, ok := c.ModuleVersions[namespace]; ok {
+		return version
+	}
+	return defaultModuleVersion
+}
+
+// maxBodySize returns the maximum size of a request body, falling back to the default when unset.
+func (c *HttpConfig) maxBodySize() int64 {
+	if c.MaxBodySize <= 0 {
+		return defaultMaxBodySize
+	}
+	return c.MaxBodySize
+}
+
+// compressionThreshold returns the size from which responses are compressed, falling back to the
+// default when unset.
+func (c *HttpConfig) compressionThreshold() int {
+	if c.CompressionThreshold <= 0 {
+		return defaultCompressionSize
+	}
+	return c.CompressionThreshold
+}
+
+// sendBufferSize returns how many outgoing messages are buffered per connection, falling back to the
+// default when unset.
+func (c *WebsocketConfig) sendBufferSize() int {
+	if c.SendBufferSize <= 0 {
+		return defaultSendBufferSize
+	}
+	return c.SendBufferSize
+}
+
+// slowConsumerPolicy returns the configured slow consumer policy, blocking by default.
+func (c *WebsocketConfig) slowConsumerPolicy() (SlowConsumerPolicy, error) {
+	switch c.SlowConsumerPolicy {
+	case "":
+		return SlowConsumerBlock, nil
+	case SlowConsumerBlock, SlowConsumerDrop, SlowConsumerDisconnect:
+		return c.SlowConsumerPolicy, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidSlowConsumerPolicy, c.SlowConsumerPolicy)
+	}
+}
+
+// pongTimeout returns how long a connection may stay silent before it is closed, falling back to the
+// default when unset.
+func (c *WebsocketConfig) pongTimeout() time.Duration {
+	if c.PongTimeout <= 0 {
+		return defaultPongTimeout
+	}
+	return c.PongTimeout
+}
+
+// pingInterval returns how often connections are pinged, by default 90% of the pong timeout.
+func (c *WebsocketConfig) pingInterval() time.Duration {
+	if c.PingInterval <= 0 {
+		return c.pongTimeout() * 9 / 10
+	}
+	return c.PingInterval
+}
+
+// writeTimeout returns the deadline of each write, falling back to the default when unset.
+func (c *WebsocketConfig) writeTimeout() time.Duration {
+	if c.WriteTimeout <= 0 {
+		return defaultWriteTimeout
+	}
+	return c.WriteTimeout
+}
+
+// checkOrigin reports whether the upgrade request comes from an allowed origin. Requests without Origin
+// header are not sent by browsers and are always allowed.
+func (c *WebsocketConfig) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(c.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate checks the consistency of the websocket settings.
+func (c *WebsocketConfig) validate() error {
+	if _, err := c.slowConsumerPolicy(); err != nil {
+		return err
+	}
+
+	if c.pingInterval() >= c.pongTimeout() {
+		return ErrInvalidPingInterval
+	}
+
+	return nil
+}
+
+// validate checks the IPC settings.
+func (c *IpcConfig) validate() error {
+	if c.Path == "" {
+		return ErrMissingIpcPath
+	}
+
+	return nil
+}
+
+// reloadInterval returns how often the certificate files are checked for changes, falling back to the
+// default when unset.
+func (c *TLSConfig) reloadInterval() time.Duration {
+	if c.ReloadInterval <= 0 {
+		return defaultCertReloadInterval
+	}
+	return c.ReloadInterval
+}
+
+// discoveryEnabled reports whether the OpenRPC document is served.
+func (c *RpcConfig) discoveryEnabled() bool {
+	return c.Discovery != nil && c.Discovery.Enabled
+}
+
+// endpoint returns the path serving the OpenRPC document, falling back to the default when unset.
+func (c *DiscoveryConfig) endpoint() string {
+	if c.Endpoint == "" {
+		return defaultDiscoveryEndpoint
+	}
+	return c.Endpoint
+}
+
+// title returns the title of the OpenRPC document, falling back to the default when unset.
+func (c *DiscoveryConfig) title() string {
+	if c.Title == "" {
+		return defaultDiscoveryTitle
+	}
+	return c.Title
+}
+
+// version returns the API version of the OpenRPC document, falling back to the default when unset.
+func (c *DiscoveryConfig) version() string {
+	if c.Version == "" {
+		return defaultDiscoveryVersion
+	}
+	return c.Version
+}
+
+// authEnabled reports whether requests must be authenticated.
+func (c *RpcConfig) authEnabled() bool {
+	return c.Auth != nil && c.Auth.Enabled
+}
+
+// jwtIatSkew returns the maximum difference between the iat claim of a JWT and the current time, falling
+// back to the default when unset.
+func (c *AuthConfig) jwtIatSkew() time.Duration {
+	if c.JWTIatSkew <= 0 {
+		return defaultJWTIatSkew
+	}
+	return c.JWTIatSkew
+}
+
+// apiKeyHeader returns the header carrying API keys, falling back to the default when unset.
+func (c *AuthConfig) apiKeyHeader() string {
+	if c.APIKeyHeader == "" {
+		return defaultAPIKeyHeader
+	}
+	return c.APIKeyHeader
+}
+
+// apiKeyQueryParam returns the query param carrying API keys, falling back to the default when unset.
+func (c *AuthConfig) apiKeyQueryParam() string {
+	if c.APIKeyQueryParam == "" {
+		return defaultAPIKeyQueryParam
+	}
+	return c.APIKeyQueryParam
+}
+
+// rateLimitEnabled reports whether requests are rate limited.
+func (c *RpcConfig) rateLimitEnabled() bool {
+	return c.RateLimit != nil && c.RateLimit.Enabled
+}
+
+// burst returns the size of the buckets, by default the number of tokens refilled per second.
+func (c *RateLimitConfig) burst() int {
+	if c.Burst <= 0 {
+		return int(math.Ceil(c.Rate))
+	}
+	return c.Burst
+}
+
+// methodCost returns the number of tokens taken by a call to method.
+func (c *RateLimitConfig) methodCost(method string) int {
+	if cost, ok := c.MethodCosts[method]; ok {
+		return cost
+	}
+	return 1
+}
+
+// validate checks the consistency of the rate limiting settings.
+func (c *RateLimitConfig) validate() error {
+	if c.Rate <= 0 {
+		return fmt.Errorf("%w: rate must be positive", ErrInvalidRateLimit)
+	}
+
+	switch c.KeyBy {
+	case "", RateLimitByIP, RateLimitByAPIKey:
+	default:
+		return fmt.Errorf("%w: unknown key %s", ErrInvalidRateLimit, c.KeyBy)
+	}
+
+	for method, cost := range c.MethodCosts {
+		if cost < 0 || cost > c.burst() {
+			return fmt.Errorf("%w: cost of %s must be between 0 and the burst", ErrInvalidRateLimit, method)
+		}
+	}
+
+	return nil
 }
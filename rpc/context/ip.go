@@ -0,0 +1,21 @@
+package context
+
+import (
+	_context "context"
+)
+
+var (
+	ClientIPLabel = ClientIPContextKey("clientIP")
+)
+
+type ClientIPContextKey string
+
+func NewContextWithClientIP(ctx _context.Context, ip string) _context.Context {
+	return _context.WithValue(ctx, ClientIPLabel, ip)
+}
+
+// ClientIPFromContext returns the IP of the client, only set for HTTP and websocket requests.
+func ClientIPFromContext(ctx _context.Context) (string, bool) {
+	ip, ok := ctx.Value(ClientIPLabel).(string)
+	return ip, ok
+}
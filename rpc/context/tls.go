@@ -0,0 +1,22 @@
+package context
+
+import (
+	_context "context"
+)
+
+var (
+	ClientCertSubjectLabel = ClientCertSubjectContextKey("clientCertSubject")
+)
+
+type ClientCertSubjectContextKey string
+
+func NewContextWithClientCertSubject(ctx _context.Context, subject string) _context.Context {
+	return _context.WithValue(ctx, ClientCertSubjectLabel, subject)
+}
+
+// ClientCertSubjectFromContext returns the subject of the verified client certificate, only set when
+// the server requires client certificates.
+func ClientCertSubjectFromContext(ctx _context.Context) (string, bool) {
+	subject, ok := ctx.Value(ClientCertSubjectLabel).(string)
+	return subject, ok
+}
@@ -0,0 +1,30 @@
+package context
+
+import (
+	_context "context"
+)
+
+var (
+	TransportLabel = TransportContextKey("transport")
+)
+
+type TransportContextKey string
+
+// Transport is the transport a request was received on.
+type Transport string
+
+const (
+	TransportHTTP      Transport = "http"
+	TransportWebsocket Transport = "websocket"
+	TransportIPC       Transport = "ipc"
+)
+
+func NewContextWithTransport(ctx _context.Context, transport Transport) _context.Context {
+	return _context.WithValue(ctx, TransportLabel, transport)
+}
+
+// TransportFromContext returns the transport the request was received on.
+func TransportFromContext(ctx _context.Context) (Transport, bool) {
+	transport, ok := ctx.Value(TransportLabel).(Transport)
+	return transport, ok
+}
@@ -2,26 +2,49 @@ package rpc
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
 	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
 const (
 	runtimeMethod      = "RuntimeMethod"
+	paramNamesMethod   = "ParamNames"
 	optionalTypePrefix = "optional_"
 )
 
+var (
+	errReservedMethod = errors.New("reserved method")
+)
+
+// handleJsonRpcRequest serves a single request. It returns nil when no response must be sent back.
 func (s *Server) handleJsonRpcRequest(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
+	// Requests over the limit are rejected before any work is done for them
+	if response, rejected := s.rateLimit(ctx, request); rejected {
+		return response
+	}
+
+	return s.serveJsonRpcRequest(ctx, request)
+}
+
+// serveJsonRpcRequest serves a single request already charged to the rate limit. It returns nil when no
+// response must be sent back.
+func (s *Server) serveJsonRpcRequest(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
 	var (
 		start    = time.Now()
-		response = s._handleJsonRpcRequest(ctx, request)
+		response = s.timeoutJsonRpcRequest(ctx, request)
 		duration = time.Since(start)
 	)
 
@@ -43,140 +66,324 @@ func (s *Server) handleJsonRpcRequest(ctx context.Context, request *jsonrpc.Json
 		s.metrics.MethodCalls.WithLabelValues(request.Method).Inc()
 	}
 
+	// Notifications are executed but never answered, unless the request itself is invalid
+	if request.IsNotification() && request.Validate() == nil {
+		if s.metrics.enabled {
+			s.metrics.RequestNotifications.Inc()
+		}
+
+		return nil
+	}
+
 	return response
 }
 
+// serveConnMessage serves a message received on a persistent connection (websocket, IPC) in its own
+// goroutine, counting it with requests. Subscriptions opened by the message are activated once its response
+// is queued, release is then called, if not nil.
+func (s *Server) serveConnMessage(
+	connCtx context.Context,
+	subscriptions *subscriptionRegistry,
+	sendRaw func(msg []byte),
+	message []byte,
+	requests prometheus.Counter,
+	release func(),
+) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if release != nil {
+			defer release()
+		}
+
+		ctx, activateSubscriptions := withSubscriptions(
+			rpcContext.NewContextWithTraceId(connCtx, uuid.New().String()),
+			subscriptions,
+		)
+		// Deferred first so subscriptions are only activated once the response is queued
+		defer activateSubscriptions()
+
+		if s.metrics.enabled {
+			requests.Inc()
+		}
+
+		s.handleConnMessage(ctx, message, sendRaw)
+	}()
+}
+
+// handleConnMessage serves a single or batch request received on a persistent connection (websocket, IPC)
+// and queues the encoded response, if any, with sendRaw. Responses are encoded by the request goroutine
+// rather than by the write loop of the connection, so that results aren't read after the request is served
+// and encoding doesn't hold up the other messages of the connection. Unlike HTTP responses, which are
+// written straight to the ResponseWriter, the whole encoded response is held in memory until it is sent.
+func (s *Server) handleConnMessage(ctx context.Context, message []byte, sendRaw func(msg []byte)) {
+	// Panics in API methods are recovered when dispatching, this only guards the transport itself
+	defer func() {
+		if r := recover(); r != nil {
+			sendRaw(jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", nil, nil).Marshal())
+			log.Error(ctx, "connection server execution error", "error", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	if jsonrpc.IsBatch(message) {
+		batch, errResponse := s.handleBatchRequest(ctx, message)
+		if errResponse != nil {
+			sendRaw(errResponse.Marshal())
+		} else if len(batch) > 0 {
+			sendRaw(batch.Marshal())
+		}
+		return
+	}
+
+	var request jsonrpc.JsonRpcRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		sendRaw(jsonrpc.NewJsonRpcErrorResponse(jsonrpc.ParseError, "invalid request", err.Error(), nil).Marshal())
+		return
+	}
+
+	if response := s.handleJsonRpcRequest(ctx, &request); response != nil {
+		sendRaw(response.Marshal())
+	}
+}
+
+// timeoutJsonRpcRequest serves the request within the timeout configured for its method. When the timeout
+// expires, the context of the method is cancelled and a timeout error is returned without waiting for it.
+//
+// Timeouts only stop the methods observing their context, other methods run to completion after the
+// timeout error is sent. Shutdown waits for them. Subscribe methods are always waited for, the subscription
+// is only opened if they return in time.
+func (s *Server) timeoutJsonRpcRequest(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
+	timeout := s.cfg.methodTimeout(request.Method)
+	if timeout <= 0 {
+		return s.recoverJsonRpcRequest(ctx, request)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// A subscription opened after its timeout error is sent would never be known to the client
+	if strings.HasSuffix(request.Method, subscribeMethodSuffix) {
+		return s.recoverJsonRpcRequest(ctx, request)
+	}
+
+	// The caller is tracked by wg, so the method is counted before Shutdown can stop waiting
+	s.wg.Add(1)
+
+	// The method may outlive the timeout, it gets its own copy of the request that interceptors can rewrite
+	// while the caller reads the original
+	methodRequest := *request
+
+	responseChan := make(chan *jsonrpc.JsonRpcResponse, 1)
+	go func() {
+		defer s.wg.Done()
+		responseChan <- s.recoverJsonRpcRequest(ctx, &methodRequest)
+	}()
+
+	select {
+	case response := <-responseChan:
+		return response
+	case <-ctx.Done():
+		return contextErrorResponse(ctx, request.Id)
+	}
+}
+
+// contextErrorResponse is the error response to a request whose context ended before it was served.
+func contextErrorResponse(ctx context.Context, id jsonrpc.JsonRpcId) *jsonrpc.JsonRpcResponse {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.RequestTimeout, "request timed out", nil, id)
+	}
+	return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "request cancelled", ctx.Err().Error(), id)
+}
+
+// recoverJsonRpcRequest serves the request, turning a panic in an interceptor or the API method into an
+// internal error response.
+func (s *Server) recoverJsonRpcRequest(ctx context.Context, request *jsonrpc.JsonRpcRequest) (response *jsonrpc.JsonRpcResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error(ctx, fmt.Sprintf("panic serving %s", request.Method), "error", r, "stack", string(debug.Stack()))
+
+			if s.metrics.enabled {
+				s.metrics.RequestPanics.Inc()
+			}
+
+			response = jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", nil, request.Id)
+		}
+	}()
+
+	return s.interceptJsonRpcRequest(ctx, request)
+}
+
 func (s *Server) _handleJsonRpcRequest(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
 	if err := request.Validate(); err != nil {
 		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidRequest, "invalid request", err.Error(), request.Id)
 	}
 
-	// Check if method name is reserved
-	if request.Method == runtimeMethod {
-		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, "method not found: runtimeMethod is reserved", nil, request.Id)
+	// Methods of the namespaces disabled on the transport are not disclosed
+	if !s.methodEnabled(ctx, request.Method) {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, "method not found", nil, request.Id)
 	}
 
-	call := reflect.ValueOf(s.api).MethodByName(cases.Title(language.Und, cases.NoLower).String(request.Method))
-	if !call.IsValid() {
-		call = s.api.RuntimeMethod(request.Method)
-		if !call.IsValid() {
-			return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, "method not found", nil, request.Id)
+	cb, err := s.resolveCallback(request.Method)
+	if errors.Is(err, errReservedMethod) {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, fmt.Sprintf("method not found: %s is reserved", request.Method), nil, request.Id)
+	}
+	if err != nil {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", "Invalid method definition", request.Id)
+	}
+
+	if cb == nil {
+		// Methods defined by the API take precedence over the built-in methods
+		switch {
+		case request.Method == discoverMethod && s.cfg.discoveryEnabled():
+			return jsonrpc.NewJsonRpcSuccessResponse(s.openrpcDocument(ctx), request.Id)
+		case request.Method == modulesMethod:
+			return jsonrpc.NewJsonRpcSuccessResponse(s.modules(ctx), request.Id)
+		case strings.HasSuffix(request.Method, subscribeMethodSuffix):
+			return s.handleSubscribe(ctx, request)
+		case strings.HasSuffix(request.Method, unsubscribeMethodSuffix):
+			return s.handleUnsubscribe(ctx, request)
 		}
+
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, "method not found", nil, request.Id)
 	}
 
-	numIn := call.Type().NumIn()
-	numParams := len(request.Params)
-	hasOptional := hasOptionalInput(numIn, &call)
+	// Subscription methods are only served through the subscribe method of their namespace, which provides
+	// their notifier, and only them
+	if _, ok := NotifierFromContext(ctx); ok != cb.subscription {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, "method not found", nil, request.Id)
+	}
 
-	// Check if first parameter is context.Context
-	hasContextParam := numIn > 0 && call.Type().In(0).String() == "context.Context"
-	if hasContextParam {
-		numIn-- // Adjust numIn since context will be handled separately
+	if request.IsByName() {
+		params, err := namedParamsToPositional(cb, request.NamedParams)
+		if err != nil {
+			return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", err.Error(), request.Id)
+		}
+		request.Params, request.NamedParams = params, nil
 	}
 
-	if !hasValidParamLength(numParams, numIn, hasOptional) {
+	numIn := len(cb.argTypes)
+	numParams := len(request.Params)
+
+	if !hasValidParamLength(numParams, numIn, cb.hasOptional) {
 		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params count", nil, request.Id)
 	}
 
-	if isOptionalParamUndefined(numParams, numIn, hasOptional) {
-		request.Params = append(request.Params, map[string]interface{}{})
+	if isOptionalParamUndefined(numParams, numIn, cb.hasOptional) {
+		request.Params = append(request.Params, json.RawMessage("{}"))
 		numParams++
 	}
 
 	// Create args slice with room for context if needed
-	args := make([]reflect.Value, numParams)
-	paramStartIdx := 0
-
-	if hasContextParam {
-		// Insert context as first argument and shift other args
-		args = make([]reflect.Value, numParams+1)
-		args[paramStartIdx] = reflect.ValueOf(ctx)
-		paramStartIdx++
+	args := make([]reflect.Value, 0, numParams+1)
+	if cb.hasCtx {
+		args = append(args, reflect.ValueOf(ctx))
 	}
 
 	for i, arg := range request.Params {
-		paramIndex := i + paramStartIdx
-		paramType := call.Type().In(paramIndex)
-
-		// Handle numeric types
-		if isNumericType(paramType.Kind()) {
-			if val, ok := arg.(float64); ok {
-				args[paramIndex] = reflect.ValueOf(convertNumber(val, paramType.Kind()))
-				continue
-			}
-			return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", formatConversionErrMsg(paramIndex, &call), request.Id)
+		// Each param is decoded into the type declared by the method
+		val := reflect.New(cb.argTypes[i])
+		if err := json.Unmarshal(arg, val.Interface()); err != nil {
+			return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", formatConversionErrMsg(i, cb.argTypes[i]), request.Id)
 		}
+		args = append(args, val.Elem())
+	}
 
-		// Handle other basic types
-		switch paramType.Kind() {
-		case reflect.Interface:
-			args[paramIndex] = reflect.ValueOf(arg)
-		case reflect.Map:
-			val, ok := arg.(map[string]any)
-			if !ok {
-				return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", formatConversionErrMsg(paramIndex, &call), request.Id)
-			}
-			args[paramIndex] = reflect.ValueOf(val)
-		case reflect.Slice:
-			val, ok := arg.([]interface{})
-			if !ok {
-				return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", formatConversionErrMsg(paramIndex, &call), request.Id)
-			}
-			args[paramIndex] = reflect.ValueOf(val)
-		case reflect.String:
-			val, ok := arg.(string)
-			if !ok {
-				return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", formatConversionErrMsg(paramIndex, &call), request.Id)
-			}
-			args[paramIndex] = reflect.ValueOf(val)
-		case reflect.Bool:
-			val, ok := arg.(bool)
-			if !ok {
-				return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", formatConversionErrMsg(paramIndex, &call), request.Id)
+	value := cb.fn.Call(args)
+
+	if cb.hasErr {
+		if err, ok := value[len(value)-1].Interface().(error); ok && err != nil {
+			// Errored
+			if errors.Is(err, context.DeadlineExceeded) {
+				return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.RequestTimeout, "request timed out", err.Error(), request.Id)
 			}
-			args[paramIndex] = reflect.ValueOf(val)
-		default:
-			return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", "Invalid method definition", request.Id)
+			return jsonrpc.NewJsonRpcErrorResponseFromError(err, jsonrpc.InvalidRequest, request.Id)
 		}
+		value = value[:len(value)-1]
 	}
 
-	value := call.Call(args)
-
-	if len(value) == 0 {
-		// Nothing returned, empty success response
+	switch len(value) {
+	case 0:
+		// Success without result, return empty success response
 		return jsonrpc.NewJsonRpcSuccessResponse("", request.Id)
+	case 1:
+		if cb.omitsResult() {
+			return jsonrpc.NewJsonRpcSuccessResponse("", request.Id)
+		}
+		// Success with single result, return as a single value
+		return jsonrpc.NewJsonRpcSuccessResponse(value[0].Interface(), request.Id)
+	default:
+		// Success with multiple results, return as an array
+		result := make([]interface{}, len(value))
+		for i := range value {
+			result[i] = value[i].Interface()
+		}
+		return jsonrpc.NewJsonRpcSuccessResponse(result, request.Id)
 	}
+}
 
-	if err, ok := value[len(value)-1].Interface().(error); ok && err != nil {
-		// Errored
-		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidRequest, err.Error(), nil, request.Id)
+// resolveCallback looks up the method serving methodName, the returned callback is nil if there is none.
+// Methods of a Registry are looked up by their wire name, methods of other APIs are looked up by reflection
+// and cached, so that their signature is only inspected once.
+func (s *Server) resolveCallback(methodName string) (*callback, error) {
+	if registry, ok := s.api.(*Registry); ok {
+		return registry.callback(methodName), nil
 	}
 
-	if len(value) > 2 {
-		// Success with multiple results, return as an array
-		result := make([]interface{}, len(value)-1)
-		for i := 0; i < len(value)-1; i++ {
-			result[i] = value[i].Interface()
+	if cached, ok := s.callbacks.Load(methodName); ok {
+		return cached.(*callback), nil
+	}
+
+	goMethodName := cases.Title(language.Und, cases.NoLower).String(methodName)
+
+	// Check if method name is reserved
+	switch goMethodName {
+	case runtimeMethod, paramNamesMethod:
+		return nil, errReservedMethod
+	}
+
+	// Runtime methods may change over time, only methods of the API type are cached
+	cacheable := true
+
+	call := reflect.ValueOf(s.api).MethodByName(goMethodName)
+	if !call.IsValid() {
+		call = s.api.RuntimeMethod(methodName)
+		if !call.IsValid() {
+			return nil, nil
 		}
-		return jsonrpc.NewJsonRpcSuccessResponse(result, request.Id)
+		cacheable = false
 	}
 
-	if len(value) == 1 {
-		// Success without result, return empty success response
-		return jsonrpc.NewJsonRpcSuccessResponse("", request.Id)
+	cb, err := newCallback(call)
+	if err != nil {
+		return nil, err
+	}
+
+	if namedParamsApi, ok := s.api.(NamedParamsApi); ok {
+		if err := cb.setParamNames(namedParamsApi.ParamNames(methodName)); err != nil {
+			return nil, err
+		}
 	}
 
-	// Success with single result, return as a single value
-	return jsonrpc.NewJsonRpcSuccessResponse(value[0].Interface(), request.Id)
+	if cacheable {
+		s.callbacks.Store(methodName, cb)
+	}
+
+	return cb, nil
 }
 
-// hasOptionalInput checks if the API method has defined an optional final input:
-//  1. The input must start with the "optional_" prefix in its name.
-//  2. The input must be of kind Map.
-func hasOptionalInput(numIn int, call *reflect.Value) bool {
-	return numIn > 0 &&
-		strings.HasPrefix(call.Type().In(numIn-1).Name(), optionalTypePrefix) &&
-		call.Type().In(numIn-1).Kind() == reflect.Map
+// resolveCallbacks resolves the methods of the API type ahead of the first requests, so that no request
+// pays for the inspection of their signature. Methods that can't be served are left out of the cache.
+func (s *Server) resolveCallbacks() {
+	// Methods of a Registry are resolved at registration
+	if _, ok := s.api.(*Registry); ok {
+		return
+	}
+
+	for _, name := range s.methodNames() {
+		if _, err := s.resolveCallback(name); err != nil {
+			log.Debug(context.Background(), "method can't be served", "method", name, "err", err)
+		}
+	}
 }
 
 // hasValidParamLength checks if the number of parameters in the request is correct:
@@ -190,44 +397,3 @@ func hasValidParamLength(numParams, numIn int, hasOptional bool) bool {
 func isOptionalParamUndefined(numParams, numIn int, hasOptional bool) bool {
 	return hasOptional && numParams == numIn-1
 }
-
-func isNumericType(kind reflect.Kind) bool {
-	switch kind {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-		reflect.Float32, reflect.Float64:
-		return true
-	}
-	return false
-}
-
-func convertNumber(val float64, kind reflect.Kind) interface{} {
-	switch kind {
-	case reflect.Int:
-		return int(val)
-	case reflect.Int8:
-		return int8(val)
-	case reflect.Int16:
-		return int16(val)
-	case reflect.Int32:
-		return int32(val)
-	case reflect.Int64:
-		return int64(val)
-	case reflect.Uint:
-		return uint(val)
-	case reflect.Uint8:
-		return uint8(val)
-	case reflect.Uint16:
-		return uint16(val)
-	case reflect.Uint32:
-		return uint32(val)
-	case reflect.Uint64:
-		return uint64(val)
-	case reflect.Float32:
-		return float32(val)
-	case reflect.Float64:
-		return val
-	default:
-		return val
-	}
-}
@@ -3,7 +3,13 @@ package rpc
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
 
 	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
 	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
@@ -18,7 +24,7 @@ type HttpRoute struct {
 }
 
 func (s *Server) buildHttpRoutes() []HttpRoute {
-	return []HttpRoute{
+	routes := []HttpRoute{
 		{
 			"HTTP",
 			http.MethodPost,
@@ -38,6 +44,35 @@ func (s *Server) buildHttpRoutes() []HttpRoute {
 			http.HandlerFunc(s.hcCallback),
 		},
 	}
+
+	if s.cfg.discoveryEnabled() {
+		routes = append(routes, HttpRoute{
+			"HTTP",
+			http.MethodGet,
+			s.cfg.Discovery.endpoint(),
+			s.discoveryHandler,
+		})
+	}
+
+	// Routes after every fixed path, so that they take precedence over keys
+	if s.cfg.authEnabled() && s.cfg.Auth.APIKeyInPath {
+		routes = append(routes,
+			HttpRoute{
+				"HTTP",
+				http.MethodPost,
+				"/{" + apiKeyPathVar + "}",
+				s.httpHandler,
+			},
+			HttpRoute{
+				"HTTP",
+				http.MethodGet,
+				"/{" + apiKeyPathVar + "}",
+				s.httpHandler,
+			},
+		)
+	}
+
+	return routes
 }
 
 func (s *Server) httpHandler(w http.ResponseWriter, r *http.Request) {
@@ -49,33 +84,185 @@ func (s *Server) httpHandler(w http.ResponseWriter, r *http.Request) {
 		traceId = uuid.New().String()
 	}
 
-	ctx := rpcContext.NewContextWithTraceId(context.Background(), traceId)
-
 	if r.Header.Get("Upgrade") == "websocket" {
 		if !s.cfg.Websocket.Enabled {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		s.websocketHandler(ctx, w, r)
+		// The request context ends with the handler, websocket connections have their own
+		ctx := newRequestContext(context.Background(), r, traceId, rpcContext.TransportWebsocket)
+
+		// Connections are authenticated once, all their messages are sent by the same client
+		principal, ok := s.authenticateRequest(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		s.websocketHandler(withPrincipal(ctx, principal), w, r)
 		return
 	}
 
+	// Cancelled when the client goes away
+	ctx := newRequestContext(r.Context(), r, traceId, rpcContext.TransportHTTP)
+
 	if !s.cfg.HTTP.Enabled {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	principal, ok := s.authenticateRequest(ctx, w, r)
+	if !ok {
+		return
+	}
+	ctx = withPrincipal(ctx, principal)
+
 	if s.metrics.enabled {
 		s.metrics.RequestHttp.Inc()
 	}
 
+	// Requests are only accepted in the body of a POST, GET is reserved to websocket upgrades
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHttpError(w, http.StatusMethodNotAllowed, jsonrpc.InvalidRequest, "method not allowed", r.Method)
+		return
+	}
+
+	if s.cfg.HTTP.StrictContentType {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			writeHttpError(w, http.StatusUnsupportedMediaType, jsonrpc.InvalidRequest, "unsupported content type", r.Header.Get("Content-Type"))
+			return
+		}
+	}
+
+	maxBodySize := s.cfg.HTTP.maxBodySize()
+	if r.ContentLength > maxBodySize {
+		writeHttpError(w, http.StatusRequestEntityTooLarge, jsonrpc.InvalidRequest, "request too large", fmt.Sprintf("body exceeds %d bytes", maxBodySize))
+		return
+	}
+
+	var (
+		contentEncoding = r.Header.Get("Content-Encoding")
+		compressed      = &countingReader{Reader: http.MaxBytesReader(w, r.Body, maxBodySize)}
+	)
+
+	decoder, err := newBodyDecoder(contentEncoding, compressed)
+	if errors.Is(err, ErrUnsupportedContentEncoding) {
+		writeHttpError(w, http.StatusUnsupportedMediaType, jsonrpc.InvalidRequest, "unsupported content encoding", contentEncoding)
+		return
+	}
+
+	var body []byte
+	if err == nil {
+		defer decoder.Close()
+
+		// The decompressed body is bound to the same limit, one extra byte tells it's exceeded
+		body, err = io.ReadAll(io.LimitReader(decoder, maxBodySize+1))
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || int64(len(body)) > maxBodySize {
+		writeHttpError(w, http.StatusRequestEntityTooLarge, jsonrpc.InvalidRequest, "request too large", fmt.Sprintf("body exceeds %d bytes", maxBodySize))
+		return
+	}
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, jsonrpc.ParseError, "invalid request", err.Error())
+		return
+	}
+
+	if s.metrics.enabled && contentEncoding != "" && len(body) > 0 {
+		s.metrics.RequestCompressionRatio.WithLabelValues(strings.ToLower(contentEncoding)).Observe(float64(compressed.n) / float64(len(body)))
+	}
+
+	// Set before the body is compressed, the type can't be sniffed from compressed data
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.cfg.HTTP.Compression {
+		if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+			cw := newCompressWriter(w, encoding, s.cfg.HTTP.compressionThreshold(), s.metrics)
+			defer cw.Close()
+			w = cw
+		}
+	}
+
+	if jsonrpc.IsBatch(body) {
+		s.httpBatchHandler(ctx, w, body)
+		return
+	}
+
+	// Unmarshal rejects any data trailing the request
 	var request jsonrpc.JsonRpcRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(jsonrpc.NewJsonRpcErrorResponse(jsonrpc.ParseError, "invalid request", err.Error(), nil).Marshal())
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeHttpError(w, http.StatusBadRequest, jsonrpc.ParseError, "invalid request", err.Error())
 		return
 	}
 
-	w.Write(s.handleJsonRpcRequest(ctx, &request).Marshal())
+	// Only rejections of the rate limiter are answered with 429, methods may return LimitExceeded themselves
+	response, rejected := s.rateLimit(ctx, &request)
+	if !rejected {
+		response = s.serveJsonRpcRequest(ctx, &request)
+	}
+
+	if response == nil {
+		// Notifications are only answered with a status
+		status := http.StatusNoContent
+		if rejected {
+			status = http.StatusTooManyRequests
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	if rejected {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+
+	response.Encode(w)
+}
+
+func (s *Server) httpBatchHandler(ctx context.Context, w http.ResponseWriter, body []byte) {
+	batch, errResponse := s.handleBatchRequest(ctx, body)
+	if errResponse != nil {
+		if errResponse.Error.Code == jsonrpc.ParseError {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		errResponse.Encode(w)
+		return
+	}
+
+	if len(batch) == 0 {
+		// Only notifications in the batch
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	batch.Encode(w)
+}
+
+// writeHttpError answers with the given HTTP status and a JSON-RPC error response without id.
+func writeHttpError(w http.ResponseWriter, status int, code int, message string, data interface{}) {
+	w.WriteHeader(status)
+	jsonrpc.NewJsonRpcErrorResponse(code, message, data, nil).Encode(w)
+}
+
+// newRequestContext derives the context of the requests received through r from parent, on the given transport.
+func newRequestContext(parent context.Context, r *http.Request, traceId string, transport rpcContext.Transport) context.Context {
+	ctx := rpcContext.NewContextWithTraceId(parent, traceId)
+	ctx = rpcContext.NewContextWithTransport(ctx, transport)
+	ctx = rpcContext.NewContextWithClientIP(ctx, remoteHost(r))
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		ctx = rpcContext.NewContextWithClientCertSubject(ctx, r.TLS.VerifiedChains[0][0].Subject.String())
+	}
+
+	return ctx
+}
+
+// remoteHost returns the IP of the client sending r.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+)
+
+// JsonRpcHandler serves a single request.
+type JsonRpcHandler func(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse
+
+// Interceptor wraps the dispatch of every request, whatever the transport, including each element of a
+// batch. It may inspect or rewrite the request before calling next, inspect or replace the response
+// returned by next, or answer the request itself without calling next.
+//
+// Interceptors run after the request has been decoded, so unlike a Middleware they see the method name
+// and params, and they apply to every websocket message.
+type Interceptor func(ctx context.Context, request *jsonrpc.JsonRpcRequest, next JsonRpcHandler) *jsonrpc.JsonRpcResponse
+
+// Use appends interceptors to the chain wrapping the dispatch of requests. Interceptors run in the order
+// they were added, the first one being the outermost.
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.interceptorsMu.Lock()
+	defer s.interceptorsMu.Unlock()
+
+	s.interceptors = append(s.interceptors, interceptors...)
+
+	// Build the chain in reverse order so interceptors execute in the order they were provided
+	handler := JsonRpcHandler(s._handleJsonRpcRequest)
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		handler = chainInterceptor(s.interceptors[i], handler)
+	}
+	s.interceptedHandler = handler
+}
+
+func chainInterceptor(interceptor Interceptor, next JsonRpcHandler) JsonRpcHandler {
+	return func(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
+		return interceptor(ctx, request, next)
+	}
+}
+
+// interceptJsonRpcRequest serves the request through the interceptor chain.
+func (s *Server) interceptJsonRpcRequest(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
+	s.interceptorsMu.RLock()
+	handler := s.interceptedHandler
+	s.interceptorsMu.RUnlock()
+
+	if handler == nil {
+		return s._handleJsonRpcRequest(ctx, request)
+	}
+
+	return handler(ctx, request)
+}
@@ -0,0 +1,178 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+)
+
+// ipcConn is a client connected to the IPC endpoint. Requests and responses are streamed as JSON values,
+// responses are newline-delimited, as expected by geth's IPC client.
+type ipcConn struct {
+	net.Conn
+	sendChan chan []byte
+	doneChan chan struct{}
+
+	// Serializes the writes of the write loop and of the read loop replying to a malformed message
+	writeMu sync.Mutex
+
+	// Parent of the request contexts, cancelled when the connection closes or the server shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	subscriptions *subscriptionRegistry
+}
+
+func newIpcConn(conn net.Conn) *ipcConn {
+	ctx, cancel := context.WithCancel(rpcContext.NewContextWithTransport(context.Background(), rpcContext.TransportIPC))
+
+	return &ipcConn{
+		Conn:     conn,
+		sendChan: make(chan []byte, 256),
+		doneChan: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// sendRaw queues msg to the write loop, it is dropped if the connection is closed.
+func (c *ipcConn) sendRaw(msg []byte) {
+	select {
+	case c.sendChan <- msg:
+	case <-c.doneChan:
+	}
+}
+
+// write writes msg to the connection, followed by a newline.
+func (c *ipcConn) write(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.SetWriteDeadline(time.Now().Add(defaultWriteTimeout))
+	_, err := c.Write(append(msg, '\n'))
+	return err
+}
+
+// startIpcServer listens on the unix socket at path, replacing any stale socket file left behind. The
+// socket is only accessible to the user running the server.
+func startIpcServer(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0751); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	log.Info(context.Background(), "IPC server started", "path", path)
+
+	return ln, nil
+}
+
+func (s *Server) ipcAcceptLoop(ln net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdownChan:
+			default:
+				log.Error(context.Background(), "ipcAcceptLoop: failed to accept connection", "err", err)
+			}
+			return
+		}
+
+		conn := newIpcConn(c)
+		conn.subscriptions = newSubscriptionRegistry(conn.sendRaw, s.metrics)
+
+		s.wg.Add(2)
+		go s.ipcWriteLoop(conn)
+		go s.ipcReadLoop(conn)
+
+		if s.metrics.enabled {
+			s.metrics.IpcConnections.Inc()
+		}
+	}
+}
+
+func (s *Server) ipcReadLoop(conn *ipcConn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.cancel()
+		conn.subscriptions.closeAll()
+		conn.Close()
+		close(conn.doneChan)
+
+		if s.metrics.enabled {
+			s.metrics.IpcConnections.Dec()
+		}
+	}()
+
+	decoder := json.NewDecoder(conn)
+
+	for {
+		var message json.RawMessage
+		if err := decoder.Decode(&message); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Error(context.Background(), "ipcReadLoop: failed to read message", "err", err)
+
+			// The stream can't be resynchronized after malformed JSON, the client is told why before closing
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				conn.write(jsonrpc.NewJsonRpcErrorResponse(jsonrpc.ParseError, "invalid request", err.Error(), nil).Marshal())
+			}
+			return
+		}
+
+		s.serveConnMessage(conn.ctx, conn.subscriptions, conn.sendRaw, message, s.metrics.RequestIpc, nil)
+	}
+}
+
+func (s *Server) ipcWriteLoop(conn *ipcConn) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.shutdownChan:
+			conn.cancel()
+
+			// Unblocks the read loop
+			conn.Close()
+			return
+
+		case <-conn.doneChan:
+			return
+
+		case msg := <-conn.sendChan:
+			// Messages are newline-delimited
+			if err := conn.write(msg); err != nil {
+				log.Error(context.Background(), "ipcWriteLoop: failed to write message", "err", err)
+				return
+			}
+		}
+	}
+}
@@ -10,9 +10,55 @@ const (
 	MethodNotFound = -32601
 	InvalidParams  = -32602
 	InternalError  = -32603
+
+	// Codes commonly used by Ethereum clients
+	ExecutionReverted = 3
+	ServerError       = -32000
+	RequestTimeout    = -32002
+	LimitExceeded     = -32005
 )
 
 var (
 	ErrInvalidJsonRpcVersion = errors.New("invalid jsonrpc version")
 	ErrInvalidJsonRpcId      = errors.New("invalid jsonrpc id")
+	ErrInvalidJsonRpcParams  = errors.New("invalid jsonrpc params: must be an array or an object")
+	ErrEmptyBatch            = errors.New("empty batch")
+	ErrBatchTooLarge         = errors.New("batch too large")
 )
+
+// Error can be implemented by errors returned from API methods to set the code of the JSON-RPC error.
+// It matches geth's rpc.Error interface.
+type Error interface {
+	error
+	ErrorCode() int
+}
+
+// DataError can be implemented by errors returned from API methods to set the data of the JSON-RPC error.
+// It matches geth's rpc.DataError interface.
+type DataError interface {
+	error
+	ErrorData() interface{}
+}
+
+// NewJsonRpcErrorFromError converts an error returned by an API method to a JSON-RPC error. The code and
+// data are taken from the first errors in the chain implementing Error and DataError, defaultCode is used
+// if none implements Error.
+func NewJsonRpcErrorFromError(err error, defaultCode int) *JsonRpcError {
+	if rpcErr, ok := err.(*JsonRpcError); ok {
+		return rpcErr
+	}
+
+	code := defaultCode
+	var codeErr Error
+	if errors.As(err, &codeErr) {
+		code = codeErr.ErrorCode()
+	}
+
+	var data interface{}
+	var dataErr DataError
+	if errors.As(err, &dataErr) {
+		data = dataErr.ErrorData()
+	}
+
+	return NewJsonRpcError(code, err.Error(), data)
+}
@@ -1,19 +1,139 @@
 package jsonrpc
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 )
 
 const (
 	version = "2.0"
 )
 
+// JsonRpcId holds the raw id of a request, as sent by the client. It is empty when the id member
+// is absent from the request (notification), and holds "null" when the id was explicitly set to null.
+type JsonRpcId json.RawMessage
+
+func NewJsonRpcId(id interface{}) JsonRpcId {
+	raw, _ := json.Marshal(id)
+	return JsonRpcId(raw)
+}
+
+// IsAbsent reports whether the id member was missing from the request.
+func (id JsonRpcId) IsAbsent() bool {
+	return len(id) == 0
+}
+
+// IsNull reports whether the id member was explicitly set to null.
+func (id JsonRpcId) IsNull() bool {
+	return string(id) == "null"
+}
+
+func (id JsonRpcId) String() string {
+	return string(id)
+}
+
+func (id JsonRpcId) MarshalJSON() ([]byte, error) {
+	if id.IsAbsent() {
+		return []byte("null"), nil
+	}
+	return id, nil
+}
+
+func (id *JsonRpcId) UnmarshalJSON(data []byte) error {
+	*id = append((*id)[:0], data...)
+	return nil
+}
+
+// JsonRpcRequest is a request received by the server. Params given by-position are held in Params,
+// params given by-name are held in NamedParams, at most one of them is set.
 type JsonRpcRequest struct {
-	Version string        `json:"jsonrpc"`
-	Method  string        `json:"method"`
-	Params  []interface{} `json:"params"`
-	Id      interface{}   `json:"id"`
+	Version     string                     `json:"jsonrpc"`
+	Method      string                     `json:"method"`
+	Params      []json.RawMessage          `json:"-"`
+	NamedParams map[string]json.RawMessage `json:"-"`
+	Id          JsonRpcId                  `json:"id,omitempty"`
+
+	// Set when the params member is valid JSON but neither an array nor an object, see Validate
+	invalidParams bool
+}
+
+// NewJsonRpcRequest builds a request with the given params by-position. The request is a notification
+// if id is absent (nil).
+func NewJsonRpcRequest(id JsonRpcId, method string, params ...interface{}) (*JsonRpcRequest, error) {
+	request := &JsonRpcRequest{
+		Version: version,
+		Method:  method,
+		Id:      id,
+	}
+
+	if len(params) > 0 {
+		request.Params = make([]json.RawMessage, len(params))
+		for i, param := range params {
+			raw, err := json.Marshal(param)
+			if err != nil {
+				return nil, fmt.Errorf("invalid param %d: %w", i, err)
+			}
+			request.Params[i] = raw
+		}
+	}
+
+	return request, nil
+}
+
+// jsonRpcRequest has the fields of JsonRpcRequest without its (un)marshalling methods.
+type jsonRpcRequest JsonRpcRequest
+
+func (r *JsonRpcRequest) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		*jsonRpcRequest
+		Params json.RawMessage `json:"params"`
+	}{
+		jsonRpcRequest: (*jsonRpcRequest)(r),
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	r.Params, r.NamedParams, r.invalidParams = nil, nil, false
+
+	params := bytes.TrimLeft(raw.Params, " \t\r\n")
+	switch {
+	case len(params) == 0, string(params) == "null":
+		return nil
+	case params[0] == '[':
+		return json.Unmarshal(params, &r.Params)
+	case params[0] == '{':
+		return json.Unmarshal(params, &r.NamedParams)
+	default:
+		// The request is well-formed JSON, it is rejected as an invalid request by Validate
+		r.invalidParams = true
+		return nil
+	}
+}
+
+func (r *JsonRpcRequest) MarshalJSON() ([]byte, error) {
+	raw := struct {
+		*jsonRpcRequest
+		Params interface{} `json:"params,omitempty"`
+	}{
+		jsonRpcRequest: (*jsonRpcRequest)(r),
+	}
+
+	if r.NamedParams != nil {
+		raw.Params = r.NamedParams
+	} else if r.Params != nil {
+		raw.Params = r.Params
+	}
+
+	return json.Marshal(raw)
+}
+
+// IsByName reports whether the params of the request are given by-name.
+func (r *JsonRpcRequest) IsByName() bool {
+	return r.NamedParams != nil
 }
 
 func (r *JsonRpcRequest) Validate() error {
@@ -21,15 +141,28 @@ func (r *JsonRpcRequest) Validate() error {
 		return ErrInvalidJsonRpcVersion
 	}
 
-	switch r.Id.(type) {
-	case string, float64:
-	default:
-		return ErrInvalidJsonRpcId
+	if r.invalidParams {
+		return ErrInvalidJsonRpcParams
+	}
+
+	// The id may be absent, null, a string or a number
+	if !r.Id.IsAbsent() && !r.Id.IsNull() {
+		switch c := r.Id[0]; {
+		case c == '"', c == '-', c >= '0' && c <= '9':
+		default:
+			return ErrInvalidJsonRpcId
+		}
 	}
 
 	return nil
 }
 
+// IsNotification reports whether the request is a notification, i.e. has no id member.
+// The server must not reply to notifications.
+func (r *JsonRpcRequest) IsNotification() bool {
+	return r.Id.IsAbsent()
+}
+
 type JsonRpcError struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
@@ -48,14 +181,22 @@ func (e *JsonRpcError) Error() string {
 	return fmt.Sprintf("code: %d, message: %s, data: %v", e.Code, e.Message, e.Data)
 }
 
+func (e *JsonRpcError) ErrorCode() int {
+	return e.Code
+}
+
+func (e *JsonRpcError) ErrorData() interface{} {
+	return e.Data
+}
+
 type JsonRpcResponse struct {
 	Version string        `json:"jsonrpc"`
 	Result  interface{}   `json:"result,omitempty"`
 	Error   *JsonRpcError `json:"error,omitempty"`
-	Id      interface{}   `json:"id,omitempty"`
+	Id      JsonRpcId     `json:"id"`
 }
 
-func NewJsonRpcSuccessResponse(result interface{}, id interface{}) *JsonRpcResponse {
+func NewJsonRpcSuccessResponse(result interface{}, id JsonRpcId) *JsonRpcResponse {
 	if result == nil {
 		result = ""
 	}
@@ -67,7 +208,7 @@ func NewJsonRpcSuccessResponse(result interface{}, id interface{}) *JsonRpcRespo
 	}
 }
 
-func NewJsonRpcErrorResponse(code int, message string, data interface{}, id interface{}) *JsonRpcResponse {
+func NewJsonRpcErrorResponse(code int, message string, data interface{}, id JsonRpcId) *JsonRpcResponse {
 	return &JsonRpcResponse{
 		Version: version,
 		Error:   NewJsonRpcError(code, message, data),
@@ -75,11 +216,128 @@ func NewJsonRpcErrorResponse(code int, message string, data interface{}, id inte
 	}
 }
 
+// NewJsonRpcErrorResponseFromError builds the error response for an error returned by an API method,
+// see NewJsonRpcErrorFromError.
+func NewJsonRpcErrorResponseFromError(err error, defaultCode int, id JsonRpcId) *JsonRpcResponse {
+	return &JsonRpcResponse{
+		Version: version,
+		Error:   NewJsonRpcErrorFromError(err, defaultCode),
+		Id:      id,
+	}
+}
+
 func (r *JsonRpcResponse) IsSuccess() bool {
 	return r.Error == nil
 }
 
+// Marshal encodes the response, see JsonRpcResponse.Encode.
 func (r *JsonRpcResponse) Marshal() []byte {
-	json, _ := json.Marshal(r)
-	return json
+	var buf bytes.Buffer
+	r.Encode(&buf)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// Encode writes the response to w, followed by a newline. The response isn't streamed, encoding/json
+// encodes it whole in memory before writing it to w. If its result can't be encoded or its MarshalJSON
+// method panics, an internal error response is written instead. The returned error is the error writing to w.
+func (r *JsonRpcResponse) Encode(w io.Writer) error {
+	tw := &trackingWriter{Writer: w}
+
+	err := encode(tw, r)
+	if err != nil && !tw.written {
+		// Nothing is written when the value can't be encoded
+		return json.NewEncoder(w).Encode(newMarshalErrorResponse(r.Id, err))
+	}
+
+	return err
+}
+
+// encode writes v to w with a json.Encoder, turning a panic while encoding v into an error.
+func encode(w io.Writer, v interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+func newMarshalErrorResponse(id JsonRpcId, err error) *JsonRpcResponse {
+	return NewJsonRpcErrorResponse(InternalError, "internal error", fmt.Sprintf("failed to encode result: %v", err), id)
+}
+
+// trackingWriter records whether anything was written to the underlying writer.
+type trackingWriter struct {
+	io.Writer
+	written bool
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.Writer.Write(p)
+}
+
+// JsonRpcSubscriptionResult is the params member of a subscription notification.
+type JsonRpcSubscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+// JsonRpcNotification is a request without id pushed by the server to the client.
+type JsonRpcNotification struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+func NewJsonRpcSubscriptionNotification(method string, subscription string, result interface{}) *JsonRpcNotification {
+	return &JsonRpcNotification{
+		Version: version,
+		Method:  method,
+		Params: &JsonRpcSubscriptionResult{
+			Subscription: subscription,
+			Result:       result,
+		},
+	}
+}
+
+// JsonRpcBatchResponse is the array of responses sent back for a batch request.
+type JsonRpcBatchResponse []*JsonRpcResponse
+
+// Marshal encodes the batch response, see JsonRpcResponse.Marshal.
+func (b JsonRpcBatchResponse) Marshal() []byte {
+	var buf bytes.Buffer
+	b.Encode(&buf)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// Encode writes the batch response to w one response at a time, so that only one response is held encoded
+// in memory. A response whose result can't be encoded is replaced with an internal error response without
+// affecting the others, see JsonRpcResponse.Encode.
+func (b JsonRpcBatchResponse) Encode(w io.Writer) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	for i, response := range b {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+
+		if err := response.Encode(w); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// IsBatch reports whether the raw message is a JSON array, i.e. a batch of requests.
+func IsBatch(raw []byte) bool {
+	raw = bytes.TrimLeft(raw, " \t\r\n")
+	return len(raw) > 0 && raw[0] == '['
 }
@@ -5,7 +5,8 @@ import (
 )
 
 var (
-	histogramBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.35, 0.4, 0.45, 0.5, 0.55, 0.6, 0.65, 0.7, 0.8, 0.9, 1.0, 1.5, 2.0, 3.0}
+	compressionRatioBuckets = []float64{0.01, 0.02, 0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0}
+	histogramBuckets        = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.35, 0.4, 0.45, 0.5, 0.55, 0.6, 0.65, 0.7, 0.8, 0.9, 1.0, 1.5, 2.0, 3.0}
 )
 
 type RpcMetrics struct {
@@ -13,11 +14,23 @@ type RpcMetrics struct {
 
 	RequestHttp          prometheus.Counter
 	RequestWebsocket     prometheus.Counter
+	RequestIpc           prometheus.Counter
 	RequestErrors        prometheus.Counter
+	RequestNotifications prometheus.Counter
+	RequestPanics        prometheus.Counter
 	WebsocketConnections prometheus.Gauge
+	IpcConnections       prometheus.Gauge
+	WebsocketRejected    *prometheus.CounterVec
+	WebsocketOversized   prometheus.Counter
+	WebsocketThrottled   prometheus.Counter
+	WebsocketSlow        *prometheus.CounterVec
+	RateLimited          *prometheus.CounterVec
+	Subscriptions        prometheus.Gauge
 	MethodCalls          *prometheus.CounterVec
 
-	RequestDuration *prometheus.HistogramVec
+	RequestDuration          *prometheus.HistogramVec
+	RequestCompressionRatio  *prometheus.HistogramVec
+	ResponseCompressionRatio *prometheus.HistogramVec
 }
 
 func NewRpcMetrics(reg prometheus.Registerer) *RpcMetrics {
@@ -39,16 +52,66 @@ func NewRpcMetrics(reg prometheus.Registerer) *RpcMetrics {
 		Help: "Number of requests served via Websocket",
 	})
 
+	m.RequestIpc = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rpc_request_ipc",
+		Help: "Number of requests served via IPC",
+	})
+
 	m.RequestErrors = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "rpc_request_errors",
 		Help: "Number of failed requests served",
 	})
 
+	m.RequestNotifications = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rpc_request_notifications",
+		Help: "Number of notifications served",
+	})
+
+	m.RequestPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rpc_request_panics",
+		Help: "Number of requests that panicked",
+	})
+
 	m.WebsocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "rpc_websocket_connections",
 		Help: "Number of active websocket connections",
 	})
 
+	m.IpcConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rpc_ipc_connections",
+		Help: "Number of active IPC connections",
+	})
+
+	m.WebsocketRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rpc_websocket_rejected_connections",
+		Help: "Number of websocket connections rejected by the connection limits",
+	}, []string{"reason"})
+
+	m.WebsocketOversized = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rpc_websocket_oversized_messages",
+		Help: "Number of websocket connections closed for sending a message over the size limit",
+	})
+
+	m.WebsocketThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rpc_websocket_throttled_messages",
+		Help: "Number of websocket messages delayed by the in-flight requests limit",
+	})
+
+	m.WebsocketSlow = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rpc_websocket_slow_consumers",
+		Help: "Number of messages sent to websocket connections with a full send buffer, by action taken",
+	}, []string{"action"})
+
+	m.RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rpc_rate_limited_requests",
+		Help: "Number of requests rejected by the rate limiter, by transport",
+	}, []string{"transport"})
+
+	m.Subscriptions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rpc_subscriptions",
+		Help: "Number of active subscriptions",
+	})
+
 	m.MethodCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "rpc_method_calls",
 		Help: "Number of method calls",
@@ -60,13 +123,37 @@ func NewRpcMetrics(reg prometheus.Registerer) *RpcMetrics {
 		Buckets: histogramBuckets,
 	}, []string{"method"})
 
+	m.RequestCompressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rpc_http_request_compression_ratio",
+		Help:    "Compressed to uncompressed size ratio of HTTP request bodies",
+		Buckets: compressionRatioBuckets,
+	}, []string{"encoding"})
+
+	m.ResponseCompressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rpc_http_response_compression_ratio",
+		Help:    "Compressed to uncompressed size ratio of HTTP response bodies",
+		Buckets: compressionRatioBuckets,
+	}, []string{"encoding"})
+
 	reg.MustRegister(
 		m.RequestHttp,
 		m.RequestWebsocket,
+		m.RequestIpc,
 		m.RequestErrors,
+		m.RequestNotifications,
+		m.RequestPanics,
 		m.WebsocketConnections,
+		m.IpcConnections,
+		m.WebsocketRejected,
+		m.WebsocketOversized,
+		m.WebsocketThrottled,
+		m.WebsocketSlow,
+		m.RateLimited,
+		m.Subscriptions,
 		m.MethodCalls,
 		m.RequestDuration,
+		m.RequestCompressionRatio,
+		m.ResponseCompressionRatio,
 	)
 
 	return m
@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+)
+
+const (
+	builtinNamespace = "rpc"
+	modulesMethod    = builtinNamespace + "_modules"
+)
+
+// methodNamespace returns the namespace of a method, the prefix of its name before the first underscore,
+// e.g. "eth" for "eth_sendBundle". Methods without underscore have no namespace.
+func methodNamespace(method string) string {
+	namespace, _, ok := strings.Cut(method, "_")
+	if !ok {
+		return ""
+	}
+	return namespace
+}
+
+// methodEnabled reports whether method is served on the transport of the request, to its authenticated
+// client if any. The built-in methods are served on every transport, to every client.
+func (s *Server) methodEnabled(ctx context.Context, method string) bool {
+	switch method {
+	case modulesMethod, discoverMethod:
+		return true
+	}
+
+	if principal, ok := PrincipalFromContext(ctx); ok && !principal.allows(method) {
+		return false
+	}
+
+	transport, ok := rpcContext.TransportFromContext(ctx)
+	if !ok {
+		return true
+	}
+
+	return s.cfg.moduleEnabled(transport, methodNamespace(method))
+}
+
+// methodListed reports whether method is reported to the client of the request by rpc_modules and
+// rpc.discover. Subscription methods are only listed on the transports supporting subscriptions.
+func (s *Server) methodListed(ctx context.Context, method string) bool {
+	if !s.methodEnabled(ctx, method) {
+		return false
+	}
+
+	cb, err := s.resolveCallback(method)
+	if err != nil || cb == nil {
+		return false
+	}
+
+	return !cb.subscription || subscriptionsSupported(ctx)
+}
+
+// modules returns the version of each namespace served on the transport of the request, along with the
+// built-in namespace.
+func (s *Server) modules(ctx context.Context) map[string]string {
+	modules := map[string]string{
+		builtinNamespace: s.cfg.moduleVersion(builtinNamespace),
+	}
+
+	for _, method := range s.methodNames() {
+		namespace := methodNamespace(method)
+		if namespace == "" || !s.methodListed(ctx, method) {
+			continue
+		}
+		modules[namespace] = s.cfg.moduleVersion(namespace)
+	}
+
+	return modules
+}
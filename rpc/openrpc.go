@@ -0,0 +1,332 @@
+package rpc
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+)
+
+const (
+	discoverMethod = "rpc.discover"
+	openrpcVersion = "1.3.2"
+
+	schemaRefPrefix = "#/components/schemas/"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// openrpcDocument describes the methods served by the server, see https://spec.open-rpc.org.
+type openrpcDocument struct {
+	OpenRPC    string             `json:"openrpc"`
+	Info       openrpcInfo        `json:"info"`
+	Methods    []*openrpcMethod   `json:"methods"`
+	Components *openrpcComponents `json:"components,omitempty"`
+}
+
+type openrpcInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openrpcMethod struct {
+	Name           string                      `json:"name"`
+	Description    string                      `json:"description,omitempty"`
+	Tags           []*openrpcTag               `json:"tags,omitempty"`
+	ParamStructure string                      `json:"paramStructure,omitempty"`
+	Params         []*openrpcContentDescriptor `json:"params"`
+	Result         *openrpcContentDescriptor   `json:"result"`
+}
+
+type openrpcTag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type openrpcContentDescriptor struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openrpcComponents struct {
+	Schemas map[string]*jsonSchema `json:"schemas,omitempty"`
+}
+
+// jsonSchema is the subset of JSON Schema needed to describe the Go types of the methods. The empty
+// schema accepts any value.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Items                interface{}            `json:"items,omitempty"` // *jsonSchema, or []*jsonSchema for a tuple
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+}
+
+// openrpcDocument describes the methods of the API served on the transport of the request, from their
+// signatures. Runtime methods can't be listed and are left out.
+func (s *Server) openrpcDocument(ctx context.Context) *openrpcDocument {
+	doc := &openrpcDocument{
+		OpenRPC: openrpcVersion,
+		Info: openrpcInfo{
+			Title:   s.cfg.Discovery.title(),
+			Version: s.cfg.Discovery.version(),
+		},
+		Methods: []*openrpcMethod{},
+	}
+
+	schemas := newSchemaBuilder()
+
+	for _, name := range s.methodNames() {
+		if !s.methodListed(ctx, name) {
+			continue
+		}
+
+		cb, _ := s.resolveCallback(name)
+		doc.Methods = append(doc.Methods, describeMethod(name, cb, schemas))
+	}
+
+	if len(schemas.components) > 0 {
+		doc.Components = &openrpcComponents{Schemas: schemas.components}
+	}
+
+	return doc
+}
+
+// methodNames returns the wire names of the methods of the API, sorted.
+func (s *Server) methodNames() []string {
+	if registry, ok := s.api.(*Registry); ok {
+		return registry.Methods()
+	}
+
+	var (
+		apiType = reflect.TypeOf(s.api)
+		names   = make([]string, 0, apiType.NumMethod())
+	)
+
+	for i := 0; i < apiType.NumMethod(); i++ {
+		switch name := apiType.Method(i).Name; name {
+		case runtimeMethod, paramNamesMethod:
+		default:
+			names = append(names, lowerFirst(name))
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// discoveryHandler serves the OpenRPC document over HTTP.
+func (s *Server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := rpcContext.NewContextWithTransport(r.Context(), rpcContext.TransportHTTP)
+
+	principal, ok := s.authenticateRequest(ctx, w, r)
+	if !ok {
+		return
+	}
+	ctx = withPrincipal(ctx, principal)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(s.openrpcDocument(ctx)); err != nil {
+		log.Warn(r.Context(), "failed to write OpenRPC document", "err", err)
+	}
+}
+
+func describeMethod(name string, cb *callback, schemas *schemaBuilder) *openrpcMethod {
+	method := &openrpcMethod{
+		Name:        name,
+		Description: cb.description,
+		Params:      make([]*openrpcContentDescriptor, len(cb.argTypes)),
+	}
+
+	// By-name params are only mapped onto arguments when their names are known
+	if cb.paramNames == nil {
+		method.ParamStructure = "by-position"
+	}
+
+	for i, argType := range cb.argTypes {
+		param := &openrpcContentDescriptor{
+			Name:     fmt.Sprintf("param%d", i+1),
+			Required: !cb.hasOptional || i < len(cb.argTypes)-1,
+			Schema:   schemas.schema(argType),
+		}
+		if cb.paramNames != nil {
+			param.Name = cb.paramNames[i]
+		}
+		if cb.paramDescriptions != nil {
+			param.Description = cb.paramDescriptions[i]
+		}
+		method.Params[i] = param
+	}
+
+	var resultTypes []reflect.Type
+	for i := 0; i < cb.fn.Type().NumOut(); i++ {
+		if outType := cb.fn.Type().Out(i); outType != errorType {
+			resultTypes = append(resultTypes, outType)
+		}
+	}
+
+	method.Result = &openrpcContentDescriptor{Name: "result"}
+
+	switch {
+	case len(resultTypes) == 0, cb.omitsResult():
+		// Methods without result return an empty string
+		method.Result.Schema = &jsonSchema{Type: "string"}
+	case len(resultTypes) == 1 && resultTypes[0] == subscriptionType:
+		// Subscription methods are called through the subscribe method of their namespace
+		namespace, subName, _ := strings.Cut(name, "_")
+		method.Tags = []*openrpcTag{{
+			Name:        "subscription",
+			Description: fmt.Sprintf("Opened with %s%s, the first param being %q", namespace, subscribeMethodSuffix, subName),
+		}}
+		method.Result.Name = "subscriptionId"
+		method.Result.Schema = &jsonSchema{Type: "string"}
+	case len(resultTypes) == 1:
+		method.Result.Schema = schemas.schema(resultTypes[0])
+	default:
+		// Multiple results are returned as an array
+		items := make([]*jsonSchema, len(resultTypes))
+		for i, resultType := range resultTypes {
+			items[i] = schemas.schema(resultType)
+		}
+		method.Result.Schema = &jsonSchema{Type: "array", Items: items}
+	}
+
+	return method
+}
+
+// schemaBuilder builds the schemas of Go types as encoded by encoding/json. Named structs are described
+// once in the components of the document and referenced, which also covers recursive types.
+type schemaBuilder struct {
+	names      map[reflect.Type]string
+	components map[string]*jsonSchema
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		names:      make(map[reflect.Type]string),
+		components: make(map[string]*jsonSchema),
+	}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Custom encodings can't be inspected, text is always encoded as a string
+	switch {
+	case implements(t, jsonMarshalerType):
+		return &jsonSchema{}
+	case implements(t, textMarshalerType):
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !implements(t.Elem(), jsonMarshalerType) && !implements(t.Elem(), textMarshalerType) {
+			return &jsonSchema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &jsonSchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Array:
+		return &jsonSchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		return b.structSchema(t)
+	default:
+		return &jsonSchema{}
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) *jsonSchema {
+	if t.Name() == "" {
+		return b.objectSchema(t)
+	}
+
+	name, ok := b.names[t]
+	if !ok {
+		name = b.componentName(t)
+
+		// Registered before its fields are described, so that recursive fields refer to it
+		component := &jsonSchema{}
+		b.names[t] = name
+		b.components[name] = component
+
+		*component = *b.objectSchema(t)
+	}
+
+	return &jsonSchema{Ref: schemaRefPrefix + name}
+}
+
+func (b *schemaBuilder) objectSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{
+		Type:       "object",
+		Properties: make(map[string]*jsonSchema),
+	}
+
+	for _, field := range jsonFields(t) {
+		schema.Properties[field.name] = b.schema(field.typ)
+		if !field.optional {
+			schema.Required = append(schema.Required, field.name)
+		}
+	}
+
+	return schema
+}
+
+// componentName names the schema of t after its type, qualified by its package if the name is taken.
+func (b *schemaBuilder) componentName(t reflect.Type) string {
+	candidates := []string{componentKey(t.Name()), componentKey(t.String())}
+	for _, name := range candidates {
+		if _, taken := b.components[name]; !taken {
+			return name
+		}
+	}
+
+	for i := 2; ; i++ {
+		name := fmt.Sprintf("%s_%d", candidates[1], i)
+		if _, taken := b.components[name]; !taken {
+			return name
+		}
+	}
+}
+
+// componentKey replaces the characters not allowed in component keys, e.g. those of generic type names.
+func componentKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
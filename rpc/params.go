@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// NamedParamsApi can be implemented by an Api to accept by-name params for methods taking more than a
+// single struct parameter. ParamNames returns the names of the method arguments in order, context excluded,
+// or nil if the method does not accept by-name params.
+type NamedParamsApi interface {
+	ParamNames(methodName string) []string
+}
+
+// namedParamsToPositional maps by-name params onto the arguments of cb, returning them by-position.
+//
+// Params are mapped using the argument names registered for the method if any, otherwise the method
+// must take a single struct parameter (or pointer to struct) whose fields match the keys.
+func namedParamsToPositional(cb *callback, named map[string]json.RawMessage) ([]json.RawMessage, error) {
+	argTypes := cb.argTypes
+
+	if cb.paramNames != nil {
+		// Registered names are matched exactly, unknown keys are reported first so that a key differing
+		// only by case isn't reported as a missing param
+		if unknown := unknownKeys(named, cb.paramNames, false); len(unknown) > 0 {
+			return nil, fmt.Errorf("unknown params %s", strings.Join(unknown, ", "))
+		}
+
+		positional := make([]json.RawMessage, 0, len(cb.paramNames))
+		for i, name := range cb.paramNames {
+			raw, ok := named[name]
+			if !ok {
+				if cb.hasOptional && i == len(cb.paramNames)-1 {
+					// Left unset, filled in as for by-position params
+					break
+				}
+				return nil, fmt.Errorf("missing param %q", name)
+			}
+			positional = append(positional, raw)
+		}
+
+		return positional, nil
+	}
+
+	if len(argTypes) != 1 {
+		return nil, fmt.Errorf("method does not accept by-name params")
+	}
+
+	structType := argTypes[0]
+	if structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("method does not accept by-name params")
+	}
+
+	var (
+		fields   = jsonFields(structType)
+		names    = make([]string, 0, len(fields))
+		provided = make(map[string]bool, len(named))
+	)
+
+	for key := range named {
+		provided[strings.ToLower(key)] = true
+	}
+
+	for _, field := range fields {
+		names = append(names, field.name)
+		if !field.optional && !provided[strings.ToLower(field.name)] {
+			return nil, fmt.Errorf("missing param %q", field.name)
+		}
+	}
+
+	if unknown := unknownKeys(named, names, true); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown params %s", strings.Join(unknown, ", "))
+	}
+
+	raw, err := json.Marshal(named)
+	if err != nil {
+		return nil, err
+	}
+
+	return []json.RawMessage{raw}, nil
+}
+
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// jsonFields lists the fields of a struct as seen by encoding/json. A field is optional if it is a
+// pointer or tagged with omitempty.
+func jsonFields(t reflect.Type) []jsonField {
+	fields := make([]jsonField, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, jsonFields(ft)...)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, jsonField{
+			name:     name,
+			typ:      f.Type,
+			optional: f.Type.Kind() == reflect.Pointer || strings.Contains(opts, "omitempty"),
+		})
+	}
+
+	return fields
+}
+
+// unknownKeys returns the sorted keys of named matching none of names. With foldCase, keys are compared
+// case-insensitively as encoding/json does for struct fields.
+func unknownKeys(named map[string]json.RawMessage, names []string, foldCase bool) []string {
+	normalize := func(key string) string {
+		if foldCase {
+			return strings.ToLower(key)
+		}
+		return key
+	}
+
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[normalize(name)] = true
+	}
+
+	var unknown []string
+	for key := range named {
+		if !known[normalize(key)] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"golang.org/x/time/rate"
+)
+
+const rateLimitCleanupInterval = time.Minute
+
+// rateLimiter holds a token bucket per client.
+type rateLimiter struct {
+	cfg *RateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(cfg *RateLimitConfig) (*rateLimiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &rateLimiter{
+		cfg:     cfg,
+		buckets: make(map[string]*rateBucket),
+	}, nil
+}
+
+// key returns the key of the bucket of the client sending the request, empty if it isn't limited.
+func (l *rateLimiter) key(ctx context.Context) string {
+	if l.cfg.KeyFunc != nil {
+		return l.cfg.KeyFunc(ctx)
+	}
+
+	if l.cfg.KeyBy == RateLimitByAPIKey {
+		if principal, ok := PrincipalFromContext(ctx); ok {
+			return "principal:" + principal.Name
+		}
+	}
+
+	// IPC clients have no IP
+	if ip, ok := rpcContext.ClientIPFromContext(ctx); ok && ip != "" {
+		return "ip:" + ip
+	}
+
+	return ""
+}
+
+// allow takes cost tokens from the bucket of key, it reports whether there were enough.
+func (l *rateLimiter) allow(key string, cost int) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateBucket{limiter: rate.NewLimiter(rate.Limit(l.cfg.Rate), l.cfg.burst())}
+		l.buckets[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	return bucket.limiter.AllowN(now, cost)
+}
+
+// cleanup removes the buckets refilled since they were last used, they are the same as new ones.
+func (l *rateLimiter) cleanup() {
+	var (
+		now        = time.Now()
+		refillTime = time.Duration(float64(l.cfg.burst()) / l.cfg.Rate * float64(time.Second))
+	)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > refillTime {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// cleanupLoop periodically removes the unused buckets until shutdownChan is closed.
+func (l *rateLimiter) cleanupLoop(shutdownChan <-chan struct{}) {
+	ticker := time.NewTicker(rateLimitCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.cleanup()
+		case <-shutdownChan:
+			return
+		}
+	}
+}
+
+// rateLimit charges the request to the bucket of its client, it reports whether the request is over the
+// limit along with the error response to send back, nil for notifications.
+func (s *Server) rateLimit(ctx context.Context, request *jsonrpc.JsonRpcRequest) (*jsonrpc.JsonRpcResponse, bool) {
+	if s.limiter == nil {
+		return nil, false
+	}
+
+	key := s.limiter.key(ctx)
+	if key == "" || s.limiter.allow(key, s.cfg.RateLimit.methodCost(request.Method)) {
+		return nil, false
+	}
+
+	if s.metrics.enabled {
+		transport, _ := rpcContext.TransportFromContext(ctx)
+		s.metrics.RateLimited.WithLabelValues(string(transport)).Inc()
+	}
+
+	log.Debug(ctx, "request rate limited", "method", request.Method, "key", key)
+
+	if request.IsNotification() {
+		return nil, true
+	}
+
+	return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.LimitExceeded, "rate limit exceeded", nil, request.Id), true
+}
+
+// rateLimitSubscription charges the subscription method opened by a subscribe request, on top of the subscribe
+// method charged when the request was received. Only subscription methods with a cost in MethodCosts are
+// charged, so that subscriptions cost the same as other requests by default.
+func (s *Server) rateLimitSubscription(ctx context.Context, request *jsonrpc.JsonRpcRequest) (*jsonrpc.JsonRpcResponse, bool) {
+	if s.limiter == nil {
+		return nil, false
+	}
+
+	if _, ok := s.cfg.RateLimit.MethodCosts[request.Method]; !ok {
+		return nil, false
+	}
+
+	return s.rateLimit(ctx, request)
+}
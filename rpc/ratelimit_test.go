@@ -0,0 +1,300 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitTestServer(t *testing.T, cfg *RpcConfig) (*Server, *RpcMetrics) {
+	cfg.HTTP = &HttpConfig{Enabled: true}
+	cfg.Websocket = &WebsocketConfig{Enabled: true}
+	cfg.RateLimit.Enabled = true
+
+	registry := NewRegistry()
+	for _, method := range []string{"test_cheap", "test_expensive", "test_free"} {
+		assert.NoError(t, registry.Register(method, func() string {
+			return "ok"
+		}))
+	}
+	assert.NoError(t, registry.Register("test_ticks", func(ctx context.Context) (*Subscription, error) {
+		notifier, _ := NotifierFromContext(ctx)
+		return notifier.CreateSubscription(), nil
+	}))
+
+	s := newTestServer(t, cfg, registry)
+
+	return s, s.metrics
+}
+
+// postRequest sends a single request over HTTP and returns the status and response.
+func postRequest(t *testing.T, url string, header http.Header, method string) (int, *jsonrpc.JsonRpcResponse) {
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response jsonrpc.JsonRpcResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+
+	return resp.StatusCode, &response
+}
+
+func TestServer_RateLimitHttp(t *testing.T) {
+	t.Parallel()
+
+	s, metrics := newRateLimitTestServer(t, &RpcConfig{
+		RateLimit: &RateLimitConfig{
+			Rate:  0.01,
+			Burst: 4,
+			MethodCosts: map[string]int{
+				"test_expensive": 3,
+				"test_free":      0,
+			},
+		},
+	})
+	url := "http://" + s.Addr().String()
+
+	status, response := postRequest(t, url, nil, "test_expensive")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, response.Error)
+
+	// The expensive method doesn't fit in the remaining token
+	status, response = postRequest(t, url, nil, "test_expensive")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	if assert.NotNil(t, response.Error) {
+		assert.Equal(t, jsonrpc.LimitExceeded, response.Error.Code)
+	}
+
+	status, _ = postRequest(t, url, nil, "test_cheap")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = postRequest(t, url, nil, "test_cheap")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	// Notifications over the limit have no response, only the status
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(`{"jsonrpc":"2.0","method":"test_cheap","params":[]}`))
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Free methods are never limited
+	status, _ = postRequest(t, url, nil, "test_free")
+	assert.Equal(t, http.StatusOK, status)
+
+	// Each batch element is charged and rejected on its own
+	rpcClient, err := gethrpc.Dial(url)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	batch := []gethrpc.BatchElem{
+		{Method: "test_cheap", Result: new(string)},
+		{Method: "test_free", Result: new(string)},
+	}
+	assert.NoError(t, rpcClient.BatchCall(batch))
+
+	var rpcErr gethrpc.Error
+	if assert.ErrorAs(t, batch[0].Error, &rpcErr) {
+		assert.Equal(t, jsonrpc.LimitExceeded, rpcErr.ErrorCode())
+	}
+	assert.NoError(t, batch[1].Error)
+
+	assert.Equal(t, 4.0, promtestutil.ToFloat64(metrics.RateLimited.WithLabelValues("http")))
+}
+
+func TestServer_RateLimitWebsocket(t *testing.T) {
+	t.Parallel()
+
+	s, metrics := newRateLimitTestServer(t, &RpcConfig{
+		RateLimit: &RateLimitConfig{
+			Rate:  0.01,
+			Burst: 2,
+		},
+	})
+
+	rpcClient, err := gethrpc.Dial("ws://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	var result string
+	assert.NoError(t, rpcClient.Call(&result, "test_cheap"))
+	assert.NoError(t, rpcClient.Call(&result, "test_cheap"))
+
+	// Messages on the connection share the bucket of the client
+	var rpcErr gethrpc.Error
+	if assert.ErrorAs(t, rpcClient.Call(&result, "test_cheap"), &rpcErr) {
+		assert.Equal(t, jsonrpc.LimitExceeded, rpcErr.ErrorCode())
+	}
+
+	// As do requests from the same IP over HTTP
+	status, _ := postRequest(t, "http://"+s.Addr().String(), nil, "test_cheap")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	assert.Equal(t, 1.0, promtestutil.ToFloat64(metrics.RateLimited.WithLabelValues("websocket")))
+	assert.Equal(t, 1.0, promtestutil.ToFloat64(metrics.RateLimited.WithLabelValues("http")))
+}
+
+func TestServer_RateLimitSubscription(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newRateLimitTestServer(t, &RpcConfig{
+		RateLimit: &RateLimitConfig{
+			Rate:  0.01,
+			Burst: 3,
+			MethodCosts: map[string]int{
+				"test_ticks": 2,
+			},
+		},
+	})
+
+	rpcClient, err := gethrpc.Dial("ws://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Subscribing takes the cost of the subscribe method and of the subscription method
+	sub, err := rpcClient.Subscribe(ctx, "test", make(chan struct{}), "ticks")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	defer sub.Unsubscribe()
+
+	var rpcErr gethrpc.Error
+	_, err = rpcClient.Subscribe(ctx, "test", make(chan struct{}), "ticks")
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, jsonrpc.LimitExceeded, rpcErr.ErrorCode())
+	}
+}
+
+func TestServer_RateLimitKeys(t *testing.T) {
+	t.Parallel()
+
+	// Clients sharing an IP are limited by API key
+	s, _ := newRateLimitTestServer(t, &RpcConfig{
+		Auth: &AuthConfig{
+			Enabled: true,
+			APIKeys: []APIKeyConfig{
+				{Name: "alice", Key: "alice-key"},
+				{Name: "bob", Key: "bob-key"},
+			},
+		},
+		RateLimit: &RateLimitConfig{
+			Rate:  0.01,
+			Burst: 1,
+			KeyBy: RateLimitByAPIKey,
+		},
+	})
+	url := "http://" + s.Addr().String()
+
+	for _, key := range []string{"alice-key", "bob-key"} {
+		header := http.Header{"X-Api-Key": {key}}
+
+		status, _ := postRequest(t, url, header, "test_cheap")
+		assert.Equal(t, http.StatusOK, status, key)
+
+		status, _ = postRequest(t, url, header, "test_cheap")
+		assert.Equal(t, http.StatusTooManyRequests, status, key)
+	}
+
+	// Clients sharing a custom key share a bucket
+	s2, _ := newRateLimitTestServer(t, &RpcConfig{
+		RateLimit: &RateLimitConfig{
+			Rate:  0.01,
+			Burst: 1,
+			KeyFunc: func(ctx context.Context) string {
+				return "global"
+			},
+		},
+	})
+	url2 := "http://" + s2.Addr().String()
+
+	status, _ := postRequest(t, url2, nil, "test_cheap")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = postRequest(t, url2, nil, "test_cheap")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	// Requests with an empty custom key are not limited
+	s3, _ := newRateLimitTestServer(t, &RpcConfig{
+		RateLimit: &RateLimitConfig{
+			Rate:  0.01,
+			Burst: 1,
+			KeyFunc: func(ctx context.Context) string {
+				return ""
+			},
+		},
+	})
+	url3 := "http://" + s3.Addr().String()
+
+	for i := 0; i < 3; i++ {
+		status, _ := postRequest(t, url3, nil, "test_cheap")
+		assert.Equal(t, http.StatusOK, status)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter, err := newRateLimiter(&RateLimitConfig{Rate: 3})
+	if err != nil {
+		t.Fatalf("failed to create rate limiter: %v", err)
+	}
+
+	// The burst defaults to the rate
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("a", 1))
+	}
+	assert.False(t, limiter.allow("a", 1))
+	assert.True(t, limiter.allow("b", 1))
+
+	// Buckets refilled since they were last used are removed
+	limiter.buckets["a"].lastSeen = time.Now().Add(-2 * time.Second)
+	limiter.cleanup()
+
+	assert.NotContains(t, limiter.buckets, "a")
+	assert.Contains(t, limiter.buckets, "b")
+	assert.True(t, limiter.allow("a", 1))
+
+	// Invalid settings
+	for _, cfg := range []*RateLimitConfig{
+		{Rate: 0},
+		{Rate: 1, KeyBy: "user"},
+		{Rate: 1, Burst: 2, MethodCosts: map[string]int{"test_expensive": 3}},
+		{Rate: 1, MethodCosts: map[string]int{"test_expensive": -1}},
+	} {
+		_, err := newRateLimiter(cfg)
+		assert.ErrorIs(t, err, ErrInvalidRateLimit)
+	}
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+)
+
+var (
+	ErrMethodAlreadyRegistered = errors.New("method already registered")
+	ErrNoMethodsRegistered     = errors.New("no suitable methods to register")
+)
+
+// Registry holds methods registered by their exact wire name. Signatures are validated at registration,
+// so that invalid methods are reported at startup rather than on first call.
+//
+// Registry implements Api, a *Registry can be passed to NewServer in place of a reflection based adapter.
+// Only the registered methods are served then.
+type Registry struct {
+	mu        sync.RWMutex
+	callbacks map[string]*callback
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		callbacks: make(map[string]*callback),
+	}
+}
+
+// MethodOption configures a method at registration.
+type MethodOption func(cb *callback) error
+
+// WithParamNames sets the names of the method arguments, context excluded, enabling by-name params.
+func WithParamNames(names ...string) MethodOption {
+	return func(cb *callback) error {
+		return cb.setParamNames(names)
+	}
+}
+
+// WithDescription sets the description of the method in the OpenRPC document.
+func WithDescription(description string) MethodOption {
+	return func(cb *callback) error {
+		cb.description = description
+		return nil
+	}
+}
+
+// WithParamDescriptions sets the descriptions of the method arguments in the OpenRPC document, context
+// excluded.
+func WithParamDescriptions(descriptions ...string) MethodOption {
+	return func(cb *callback) error {
+		return cb.setParamDescriptions(descriptions)
+	}
+}
+
+// Register registers fn under the exact wire name, e.g. "eth_sendBundle".
+func (r *Registry) Register(name string, fn interface{}, opts ...MethodOption) error {
+	cb, err := newCallback(reflect.ValueOf(fn))
+	if err != nil {
+		return fmt.Errorf("invalid method %s: %w", name, err)
+	}
+	cb.singleResult = true
+
+	for _, opt := range opts {
+		if err := opt(cb); err != nil {
+			return fmt.Errorf("invalid method %s: %w", name, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.callbacks[name]; ok {
+		return fmt.Errorf("%w: %s", ErrMethodAlreadyRegistered, name)
+	}
+
+	r.callbacks[name] = cb
+	return nil
+}
+
+// RegisterNamespace registers the exported methods of receiver as "<namespace>_<methodName>", the first
+// letter of the Go method name being lowercased, e.g. Eth.SendBundle is served as "eth_sendBundle".
+// Methods with a signature that can't be served are skipped.
+func (r *Registry) RegisterNamespace(namespace string, receiver interface{}) error {
+	var (
+		rcvr       = reflect.ValueOf(receiver)
+		registered = 0
+	)
+
+	for i := 0; i < rcvr.NumMethod(); i++ {
+		method := rcvr.Type().Method(i)
+		name := namespace + "_" + lowerFirst(method.Name)
+
+		if _, err := newCallback(rcvr.Method(i)); err != nil {
+			log.Debug(context.Background(), "skipping method", "method", name, "err", err)
+			continue
+		}
+
+		if err := r.Register(name, rcvr.Method(i).Interface()); err != nil {
+			return err
+		}
+		registered++
+	}
+
+	if registered == 0 {
+		return fmt.Errorf("%w: namespace %s", ErrNoMethodsRegistered, namespace)
+	}
+
+	return nil
+}
+
+// RuntimeMethod returns the method registered under methodName.
+func (r *Registry) RuntimeMethod(methodName string) reflect.Value {
+	if cb := r.callback(methodName); cb != nil {
+		return cb.fn
+	}
+	return reflect.Value{}
+}
+
+// Methods returns the wire names of the registered methods.
+func (r *Registry) Methods() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.callbacks))
+	for name := range r.callbacks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) callback(methodName string) *callback {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.callbacks[methodName]
+}
+
+func lowerFirst(name string) string {
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(first)) + name[size:]
+}
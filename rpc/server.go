@@ -2,7 +2,8 @@ package rpc
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net"
 	"net/http"
@@ -29,11 +30,49 @@ type Server struct {
 	hcCallback  HealthcheckCallback
 	middlewares []Middleware
 
+	// Set when authentication and rate limiting are enabled
+	auth    *authenticator
+	limiter *rateLimiter
+
+	// Signatures of the API methods resolved by reflection, by method name
+	callbacks sync.Map
+
+	interceptorsMu     sync.RWMutex
+	interceptors       []Interceptor
+	interceptedHandler JsonRpcHandler
+
+	// Websocket connections, in total and by remote host
+	wsConnsMu    sync.Mutex
+	wsConns      int
+	wsConnsPerIP map[string]int
+
+	listener    net.Listener
+	httpServer  *http.Server
+	ipcListener net.Listener
+
 	shutdownChan chan struct{}
+	shutdownOnce sync.Once
 	wg           sync.WaitGroup
+
+	// Closed once the server is stopped, shutdownErr is set before
+	stoppedChan chan struct{}
+	shutdownErr error
 }
 
+// NewServer starts a server listening on the host and port of cfg. If the port is 0, a free port is
+// picked, see Addr.
 func NewServer(cfg *RpcConfig, api Api, hcCallback HealthcheckCallback, registerer prometheus.Registerer, middlewares ...Middleware) (*Server, error) {
+	ln, err := net.Listen("tcp", cfg.listenAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewServerWithListener(cfg, ln, api, hcCallback, registerer, middlewares...)
+}
+
+// NewServerWithListener starts a server accepting HTTP and websocket connections from ln, the host and
+// port of cfg are ignored. ln is closed when the server shuts down, or if the server fails to start.
+func NewServerWithListener(cfg *RpcConfig, ln net.Listener, api Api, hcCallback HealthcheckCallback, registerer prometheus.Registerer, middlewares ...Middleware) (*Server, error) {
 	gethlog.SetDefault(gethlog.NewLogger(gethlog.NewTerminalHandlerWithLevel(os.Stdout, slog.LevelDebug, true)))
 
 	if hcCallback == nil {
@@ -49,22 +88,157 @@ func NewServer(cfg *RpcConfig, api Api, hcCallback HealthcheckCallback, register
 		hcCallback:   hcCallback,
 		middlewares:  middlewares,
 		shutdownChan: make(chan struct{}),
+		stoppedChan:  make(chan struct{}),
 	}
 
-	if err := startRpcServer(s.cfg.Port, s.buildHttpRoutes(), s.middlewares); err != nil {
-		return nil, err
+	s.resolveCallbacks()
+
+	if s.cfg.Websocket != nil {
+		if err := s.cfg.Websocket.validate(); err != nil {
+			ln.Close()
+			return nil, err
+		}
 	}
 
+	if s.cfg.IPC != nil && s.cfg.IPC.Enabled {
+		if err := s.cfg.IPC.validate(); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	if s.cfg.authEnabled() {
+		auth, err := newAuthenticator(s.cfg.Auth)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		s.auth = auth
+	}
+
+	if s.cfg.rateLimitEnabled() {
+		limiter, err := newRateLimiter(s.cfg.RateLimit)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		s.limiter = limiter
+	}
+
+	var tlsConfig *tls.Config
+	if s.cfg.TLS != nil && s.cfg.TLS.Enabled {
+		var (
+			reloader *certReloader
+			err      error
+		)
+
+		tlsConfig, reloader, err = buildTLSConfig(s.cfg.TLS)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			reloader.watch(s.cfg.TLS.reloadInterval(), s.shutdownChan)
+		}()
+	}
+
+	if s.cfg.IPC != nil && s.cfg.IPC.Enabled {
+		ipcListener, err := startIpcServer(s.cfg.IPC.Path)
+		if err != nil {
+			close(s.shutdownChan)
+			ln.Close()
+			return nil, err
+		}
+
+		s.ipcListener = ipcListener
+
+		s.wg.Add(1)
+		go s.ipcAcceptLoop(ipcListener)
+	}
+
+	if s.limiter != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.limiter.cleanupLoop(s.shutdownChan)
+		}()
+	}
+
+	s.listener = ln
+	s.httpServer = startRpcServer(ln, s.buildHttpRoutes(), s.middlewares, s.corsHeaders(), tlsConfig)
+
 	return s, nil
 }
 
+// Addr returns the address the server accepts HTTP and websocket connections on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close shuts the server down, waiting at most for the configured shutdown timeout.
 func (s *Server) Close() {
-	close(s.shutdownChan)
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.shutdownTimeout())
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		log.Warn(ctx, "RPC server shutdown incomplete", "err", err)
+	}
+}
+
+// Shutdown gracefully shuts the server down: it stops accepting connections, drains in-flight HTTP requests,
+// sends close frames to all websockets, closes IPC connections and waits for the handlers to return. The
+// listening port and socket are released once Shutdown returns.
+//
+// If ctx expires before the handlers are done, Shutdown returns the context error and the server keeps
+// shutting down in the background. Shutdown can be called again to wait for it.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdownChan)
+
+		// IPC connections are closed by their write loop on shutdownChan
+		if s.ipcListener != nil {
+			s.ipcListener.Close()
+		}
+
+		go s.stop()
+	})
+
+	select {
+	case <-s.stoppedChan:
+		return s.shutdownErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop waits for the server to stop once shutdownChan is closed, independently of the callers of Shutdown.
+func (s *Server) stop() {
+	defer close(s.stoppedChan)
+
+	// Stops the listener and waits for the in-flight HTTP requests. Websocket connections are hijacked
+	// and not tracked by the HTTP server, they are closed by their write loop on shutdownChan.
+	if err := s.httpServer.Shutdown(context.Background()); err != nil {
+		s.shutdownErr = err
+	}
+
 	s.wg.Wait()
+
 	log.Info(context.Background(), "RPC server stopped")
 }
 
-func startRpcServer(port uint64, routes []HttpRoute, middlewares []Middleware) error {
+// corsHeaders returns the request headers browsers are allowed to send.
+func (s *Server) corsHeaders() []string {
+	headers := []string{"Content-Type"}
+	if s.auth != nil {
+		headers = append(headers, "Authorization", s.cfg.Auth.apiKeyHeader())
+	}
+	return headers
+}
+
+func startRpcServer(ln net.Listener, routes []HttpRoute, middlewares []Middleware, corsHeaders []string, tlsConfig *tls.Config) *http.Server {
 	router := mux.NewRouter().StrictSlash(true)
 	logger := func(inner func(http.ResponseWriter, *http.Request)) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -89,20 +263,21 @@ func startRpcServer(port uint64, routes []HttpRoute, middlewares []Middleware) e
 	}
 
 	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: handlers.CORS(handlers.AllowedHeaders([]string{"Content-Type"}))(finalHandler),
+		Addr:    ln.Addr().String(),
+		Handler: handlers.CORS(handlers.AllowedHeaders(corsHeaders))(finalHandler),
 	}
 
-	ln, err := net.Listen("tcp", httpServer.Addr)
-	if err != nil {
-		return err
+	// Serves HTTPS and WSS
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
 	}
 
 	go func() {
-		log.Info(context.Background(), "RPC server started", "addr", httpServer.Addr)
-		err := httpServer.Serve(ln)
-		log.Info(context.Background(), "RPC server stopped", "err", err)
+		log.Info(context.Background(), "RPC server started", "addr", httpServer.Addr, "tls", tlsConfig != nil)
+		if err := httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(context.Background(), "RPC server failed", "err", err)
+		}
 	}()
 
-	return nil
+	return httpServer
 }
@@ -1,17 +1,59 @@
 package rpc
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
 	"github.com/FastLane-Labs/fastlane-json-rpc/testutils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
+// newTestServer starts a server serving api with cfg, on 127.0.0.1 unless cfg sets a host and on a free
+// port unless it sets one. Metrics are enabled, and the server is closed when the test ends.
+func newTestServer(tb testing.TB, cfg *RpcConfig, api Api) *Server {
+	tb.Helper()
+
+	if cfg.Host == "" {
+		cfg.Host = "127.0.0.1"
+	}
+
+	s, err := NewServer(cfg, api, nil, prometheus.NewRegistry())
+	if err != nil {
+		tb.Fatalf("failed to create server: %v", err)
+	}
+
+	tb.Cleanup(s.Close)
+
+	return s
+}
+
 func TestServer_HttpRequest(t *testing.T) {
 	testCfg := &RpcConfig{
 		Port: 8080,
@@ -30,9 +72,13 @@ func TestServer_HttpRequest(t *testing.T) {
 
 	defer s.Close()
 
+	// Methods of the API type are resolved at startup
+	_, cached := s.callbacks.Load("mock_methodA")
+	assert.True(t, cached)
+
 	tt := []struct {
 		methodCalled      string
-		methodParams      []interface{}
+		methodParams      interface{}
 		expectedHttpCode  int
 		expectedResult    interface{}
 		expectedErrorPart string
@@ -91,6 +137,84 @@ func TestServer_HttpRequest(t *testing.T) {
 			expectedHttpCode: http.StatusOK,
 			expectedResult:   true,
 		},
+		{
+			methodCalled:     "mock_methodH",
+			methodParams:     []interface{}{"value"},
+			expectedHttpCode: http.StatusOK,
+			expectedResult:   "",
+		},
+		{
+			methodCalled:     "mock_methodE",
+			methodParams:     []interface{}{"0x0000000000000000000000000000000000000001", "0x10", []string{"a", "b"}, map[string]interface{}{"nonce": "0x5"}},
+			expectedHttpCode: http.StatusOK,
+			expectedResult:   "0x0000000000000000000000000000000000000001:0x10:2:5",
+		},
+		{
+			methodCalled:      "mock_methodE",
+			methodParams:      []interface{}{"0x0000000000000000000000000000000000000001", nil, []string{}, nil},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "mock_methodE error",
+		},
+		{
+			methodCalled:      "mock_methodE",
+			methodParams:      []interface{}{"not an address", "0x10", []string{}, nil},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:      "mock_methodE",
+			methodParams:      []interface{}{"0x0000000000000000000000000000000000000001", "0x10", []int{1}, nil},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:     "mock_methodA",
+			methodParams:     map[string]interface{}{"param1": 60, "shouldError": false},
+			expectedHttpCode: http.StatusOK,
+			expectedResult:   "mock_methodA success",
+		},
+		{
+			methodCalled:      "mock_methodA",
+			methodParams:      map[string]interface{}{"param1": 60},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:      "mock_methodA",
+			methodParams:      map[string]interface{}{"param1": 60, "shouldError": false, "extra": 1},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:      "mock_methodA",
+			methodParams:      map[string]interface{}{"Param1": 60, "shouldError": false},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:     "mock_methodF",
+			methodParams:     map[string]interface{}{"name": "bundle", "amount": 3, "tags": []string{"a"}},
+			expectedHttpCode: http.StatusOK,
+			expectedResult:   "bundle:3:1",
+		},
+		{
+			methodCalled:      "mock_methodF",
+			methodParams:      map[string]interface{}{"name": "bundle"},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:      "mock_methodF",
+			methodParams:      map[string]interface{}{"name": "bundle", "amount": 3, "unknown": true},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
+		{
+			methodCalled:      "mock_methodE",
+			methodParams:      map[string]interface{}{"to": "0x0000000000000000000000000000000000000001"},
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid params",
+		},
 	}
 
 	for _, tc := range tt {
@@ -222,3 +346,1679 @@ func TestServer_WebsocketRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestServer_BatchRequest(t *testing.T) {
+	testCfg := &RpcConfig{
+		MaxBatchSize: 3,
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	api := testutils.NewMockRpcAdapter()
+
+	s := newTestServer(t, testCfg, api)
+
+	addr := s.Addr().String()
+
+	tt := []struct {
+		name              string
+		body              string
+		expectedHttpCode  int
+		expectedBatchLen  int
+		expectedErrorPart string
+	}{
+		{
+			name:             "valid batch",
+			body:             `[{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false],"id":1},{"jsonrpc":"2.0","method":"mock_methodB","params":["param",true],"id":2}]`,
+			expectedHttpCode: http.StatusOK,
+			expectedBatchLen: 2,
+		},
+		{
+			name:             "mixed invalid entries",
+			body:             `[{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false],"id":1},1,"invalid"]`,
+			expectedHttpCode: http.StatusOK,
+			expectedBatchLen: 3,
+		},
+		{
+			name:              "empty batch",
+			body:              `[]`,
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid request",
+		},
+		{
+			name:              "batch too large",
+			body:              `[1,2,3,4]`,
+			expectedHttpCode:  http.StatusOK,
+			expectedErrorPart: "invalid request",
+		},
+		{
+			name:              "malformed batch",
+			body:              `[{"jsonrpc":"2.0"`,
+			expectedHttpCode:  http.StatusBadRequest,
+			expectedErrorPart: "invalid request",
+		},
+	}
+
+	for _, tc := range tt {
+		resp, err := http.Post("http://"+addr, "application/json", bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, tc.expectedHttpCode, resp.StatusCode, tc.name)
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+
+		if tc.expectedErrorPart != "" {
+			rpcResp := struct {
+				Error map[string]interface{} `json:"error"`
+			}{}
+			if err := json.Unmarshal(body, &rpcResp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			assert.Contains(t, rpcResp.Error["message"], tc.expectedErrorPart, tc.name)
+			continue
+		}
+
+		var batchResp []map[string]interface{}
+		if err := json.Unmarshal(body, &batchResp); err != nil {
+			t.Fatalf("failed to unmarshal batch response: %v", err)
+		}
+		assert.Len(t, batchResp, tc.expectedBatchLen, tc.name)
+	}
+
+	rpcClient, err := ethclient.Dial("ws://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	var resultA, resultD string
+	batch := []gethrpc.BatchElem{
+		{Method: "mock_methodA", Args: []interface{}{60, false}, Result: &resultA},
+		{Method: "mock_methodD", Args: []interface{}{12.34, false}, Result: &resultD},
+		{Method: "mock_methodB", Args: []interface{}{"param", true}},
+	}
+
+	if err := rpcClient.Client().BatchCall(batch); err != nil {
+		t.Fatalf("failed to send batch: %v", err)
+	}
+
+	assert.NoError(t, batch[0].Error)
+	assert.Equal(t, "mock_methodA success", resultA)
+	assert.NoError(t, batch[1].Error)
+	assert.Equal(t, "0x010101", resultD)
+	assert.ErrorContains(t, batch[2].Error, "mock_methodB error")
+}
+
+func TestServer_Notification(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{},
+	}
+
+	api := testutils.NewMockRpcAdapter()
+
+	s := newTestServer(t, testCfg, api)
+
+	addr := s.Addr().String()
+
+	tt := []struct {
+		name             string
+		body             string
+		expectedHttpCode int
+		expectedBody     string
+	}{
+		{
+			name:             "notification",
+			body:             `{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false]}`,
+			expectedHttpCode: http.StatusNoContent,
+		},
+		{
+			name:             "failing notification",
+			body:             `{"jsonrpc":"2.0","method":"mock_methodA","params":[60,true]}`,
+			expectedHttpCode: http.StatusNoContent,
+		},
+		{
+			name:             "invalid notification",
+			body:             `{"jsonrpc":"1.0","method":"mock_methodA","params":[60,false]}`,
+			expectedHttpCode: http.StatusOK,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request","data":"invalid jsonrpc version"},"id":null}`,
+		},
+		{
+			name:             "null id",
+			body:             `{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false],"id":null}`,
+			expectedHttpCode: http.StatusOK,
+			expectedBody:     `{"jsonrpc":"2.0","result":"mock_methodA success","id":null}`,
+		},
+		{
+			name:             "invalid id",
+			body:             `{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false],"id":{}}`,
+			expectedHttpCode: http.StatusOK,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request","data":"invalid jsonrpc id"},"id":{}}`,
+		},
+		{
+			name:             "scalar params",
+			body:             `{"jsonrpc":"2.0","method":"mock_methodA","params":60,"id":1}`,
+			expectedHttpCode: http.StatusOK,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request","data":"invalid jsonrpc params: must be an array or an object"},"id":1}`,
+		},
+		{
+			name:             "batch with notifications",
+			body:             `[{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false]},{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false],"id":7}]`,
+			expectedHttpCode: http.StatusOK,
+			expectedBody:     `[{"jsonrpc":"2.0","result":"mock_methodA success","id":7}]`,
+		},
+		{
+			name:             "batch of notifications",
+			body:             `[{"jsonrpc":"2.0","method":"mock_methodA","params":[60,false]},{"jsonrpc":"2.0","method":"mock_methodB","params":["param",false]}]`,
+			expectedHttpCode: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range tt {
+		resp, err := http.Post("http://"+addr, "application/json", bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, tc.expectedHttpCode, resp.StatusCode, tc.name)
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+
+		if tc.expectedBody == "" {
+			assert.Empty(t, body, tc.name)
+		} else {
+			assert.JSONEq(t, tc.expectedBody, string(body), tc.name)
+		}
+	}
+}
+
+type subscriptionRpcAdapter struct {
+	*testutils.MockRpcAdapter
+}
+
+func (r *subscriptionRpcAdapter) Mock_counter(ctx context.Context, n uint64) (*Subscription, error) {
+	notifier, ok := NotifierFromContext(ctx)
+	if !ok {
+		return nil, ErrNotificationsUnsupported
+	}
+
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		for i := uint64(0); i < n; i++ {
+			notifier.Notify(sub.ID, i)
+		}
+	}()
+
+	return sub, nil
+}
+
+func TestServer_WebsocketSubscription(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	api := &subscriptionRpcAdapter{testutils.NewMockRpcAdapter()}
+
+	s := newTestServer(t, testCfg, api)
+
+	addr := s.Addr().String()
+
+	rpcClient, err := gethrpc.Dial("ws://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ch := make(chan uint64)
+	sub, err := rpcClient.Subscribe(ctx, "mock", ch, "counter", 3)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		select {
+		case n := <-ch:
+			assert.Equal(t, i, n)
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for notification %d", i)
+		}
+	}
+
+	sub.Unsubscribe()
+
+	// Subscriptions are not available over HTTP
+	httpClient, err := gethrpc.Dial("http://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer httpClient.Close()
+
+	var result interface{}
+	err = httpClient.CallContext(ctx, &result, "mock_subscribe", "counter", 3)
+	assert.ErrorContains(t, err, ErrNotificationsUnsupported.Error())
+
+	// Unknown subscriptions can't be cancelled
+	wsClient, err := gethrpc.Dial("ws://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer wsClient.Close()
+
+	err = wsClient.CallContext(ctx, &result, "mock_unsubscribe", "0x01")
+	assert.ErrorContains(t, err, ErrSubscriptionNotFound.Error())
+
+	// Subscriptions are only cancelled through the namespace they were opened in
+	var id string
+	assert.NoError(t, wsClient.CallContext(ctx, &id, "mock_subscribe", "counter", 0))
+
+	err = wsClient.CallContext(ctx, &result, "eth_unsubscribe", id)
+	assert.ErrorContains(t, err, ErrSubscriptionNotFound.Error())
+
+	var unsubscribed bool
+	assert.NoError(t, wsClient.CallContext(ctx, &unsubscribed, "mock_unsubscribe", id))
+	assert.True(t, unsubscribed)
+
+	// Notifications don't open subscriptions, their id would never be known
+	assert.NoError(t, wsClient.Notify(ctx, "mock_subscribe", "counter", 0))
+	assert.Never(t, func() bool {
+		return promtestutil.ToFloat64(s.metrics.Subscriptions) > 0
+	}, 200*time.Millisecond, 10*time.Millisecond)
+
+	// Subscription methods can't be called directly, nor other methods subscribed to
+	for _, client := range []*gethrpc.Client{httpClient, wsClient} {
+		var rpcErr gethrpc.Error
+		if assert.ErrorAs(t, client.CallContext(ctx, &result, "mock_counter", 3), &rpcErr) {
+			assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.ErrorCode())
+		}
+	}
+
+	var rpcErr gethrpc.Error
+	if assert.ErrorAs(t, wsClient.CallContext(ctx, &result, "mock_subscribe", "methodA", 1, false), &rpcErr) {
+		assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.ErrorCode())
+	}
+}
+
+func TestServer_ErrorCodes(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	api := testutils.NewMockRpcAdapter()
+
+	s := newTestServer(t, testCfg, api)
+
+	addr := s.Addr().String()
+
+	rpcClient, err := gethrpc.Dial("ws://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	tt := []struct {
+		mode            string
+		expectedCode    int
+		expectedMessage string
+		expectedData    interface{}
+	}{
+		{
+			mode:            "revert",
+			expectedCode:    jsonrpc.ExecutionReverted,
+			expectedMessage: "mock_methodG: execution reverted",
+			expectedData:    "0x08c379a0",
+		},
+		{
+			mode:            "limit",
+			expectedCode:    jsonrpc.LimitExceeded,
+			expectedMessage: "mock_methodG limit exceeded",
+		},
+		{
+			mode:            "other",
+			expectedCode:    jsonrpc.InvalidRequest,
+			expectedMessage: "mock_methodG error",
+		},
+	}
+
+	for _, tc := range tt {
+		err := rpcClient.Call(nil, "mock_methodG", tc.mode)
+
+		var rpcErr gethrpc.Error
+		if !errors.As(err, &rpcErr) {
+			t.Fatalf("expected rpc error, got %v", err)
+		}
+		assert.Equal(t, tc.expectedCode, rpcErr.ErrorCode(), tc.mode)
+		assert.Equal(t, tc.expectedMessage, rpcErr.Error(), tc.mode)
+
+		var dataErr gethrpc.DataError
+		if errors.As(err, &dataErr) {
+			assert.Equal(t, tc.expectedData, dataErr.ErrorData(), tc.mode)
+		}
+	}
+
+	// Methods returning LimitExceeded are answered with 200 over HTTP, only the rate limiter answers with 429
+	resp, err := http.Post("http://"+addr, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"mock_methodG","params":["limit"]}`))
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	var response jsonrpc.JsonRpcResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	if assert.NotNil(t, response.Error) {
+		assert.Equal(t, jsonrpc.LimitExceeded, response.Error.Code)
+	}
+}
+
+type calcNamespace struct{}
+
+func (calcNamespace) Sum(ctx context.Context, a, b int) (int, error) {
+	return a + b, nil
+}
+
+func (calcNamespace) Echo(value string) string {
+	return value
+}
+
+func (calcNamespace) Results() chan int {
+	return nil
+}
+
+func TestServer_Registry(t *testing.T) {
+	registry := NewRegistry()
+
+	err := registry.Register("eth_sendBundle", func(ctx context.Context, txs []hexutil.Bytes) (int, error) {
+		return len(txs), nil
+	}, WithParamNames("txs"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, registry.RegisterNamespace("calc", calcNamespace{}))
+
+	// Invalid registrations are reported right away
+	assert.ErrorIs(t, registry.Register("eth_sendBundle", func() {}), ErrMethodAlreadyRegistered)
+	assert.Error(t, registry.Register("not_a_function", 1))
+	assert.Error(t, registry.Register("variadic", func(args ...int) {}))
+	assert.Error(t, registry.Register("misplaced_context", func(a int, ctx context.Context) {}))
+	assert.Error(t, registry.Register("misplaced_error", func() (error, int) { return nil, 0 }))
+	assert.Error(t, registry.Register("param_names", func(a, b int) {}, WithParamNames("a")))
+	assert.ErrorIs(t, registry.RegisterNamespace("empty", struct{}{}), ErrNoMethodsRegistered)
+
+	assert.Equal(t, []string{"calc_echo", "calc_sum", "eth_sendBundle"}, registry.Methods())
+
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{},
+	}
+
+	s := newTestServer(t, testCfg, registry)
+
+	addr := s.Addr().String()
+
+	tt := []struct {
+		body         string
+		expectedBody string
+	}{
+		{
+			body:         `{"jsonrpc":"2.0","method":"eth_sendBundle","params":[["0x01","0x02"]],"id":1}`,
+			expectedBody: `{"jsonrpc":"2.0","result":2,"id":1}`,
+		},
+		{
+			body:         `{"jsonrpc":"2.0","method":"eth_sendBundle","params":{"txs":["0x01"]},"id":1}`,
+			expectedBody: `{"jsonrpc":"2.0","result":1,"id":1}`,
+		},
+		{
+			body:         `{"jsonrpc":"2.0","method":"calc_sum","params":[1,2],"id":1}`,
+			expectedBody: `{"jsonrpc":"2.0","result":3,"id":1}`,
+		},
+		{
+			body:         `{"jsonrpc":"2.0","method":"calc_echo","params":["hello"],"id":1}`,
+			expectedBody: `{"jsonrpc":"2.0","result":"hello","id":1}`,
+		},
+		{
+			body:         `{"jsonrpc":"2.0","method":"register","params":[],"id":1}`,
+			expectedBody: `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":1}`,
+		},
+		{
+			body:         `{"jsonrpc":"2.0","method":"calc_results","params":[],"id":1}`,
+			expectedBody: `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":1}`,
+		},
+	}
+
+	for _, tc := range tt {
+		resp, err := http.Post("http://"+addr, "application/json", bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+
+		assert.JSONEq(t, tc.expectedBody, string(body), tc.body)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	registry := NewRegistry()
+	err := registry.Register("test_sleep", func(ms int) bool {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return true
+	})
+	assert.NoError(t, err)
+
+	s := newTestServer(t, testCfg, registry)
+	addr := s.Addr().(*net.TCPAddr)
+
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws://"+addr.String(), nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+
+	defer wsConn.Close()
+
+	// In-flight HTTP requests are drained
+	inFlight := make(chan string)
+	go func() {
+		resp, err := http.Post("http://"+addr.String(), "application/json", bytes.NewBufferString(`{"jsonrpc":"2.0","method":"test_sleep","params":[300],"id":1}`))
+		if err != nil {
+			inFlight <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		inFlight <- string(body)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Shutdown keeps going when its context expires, later calls wait for it
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer shortCancel()
+
+	assert.ErrorIs(t, s.Shutdown(shortCtx), context.DeadlineExceeded)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","result":true,"id":1}`, <-inFlight)
+
+	// Websockets receive a close frame
+	_, _, err = wsConn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+
+	// The port is released
+	testCfg.Port = uint64(addr.Port)
+
+	s = newTestServer(t, testCfg, registry)
+	s.Close()
+	s.Close()
+}
+
+func TestServer_PanicRecovery(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	api := testutils.NewMockRpcAdapter()
+
+	s := newTestServer(t, testCfg, api)
+
+	addr := s.Addr().String()
+
+	for _, url := range []string{"http://" + addr, "ws://" + addr} {
+		rpcClient, err := gethrpc.Dial(url)
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		err = rpcClient.Call(nil, "mock_methodPanic")
+
+		var rpcErr gethrpc.Error
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.InternalError, rpcErr.ErrorCode(), url)
+		}
+
+		// The connection survives the panic
+		var result string
+		assert.NoError(t, rpcClient.Call(&result, "mock_methodA", 60, false), url)
+		assert.Equal(t, "mock_methodA success", result, url)
+
+		rpcClient.Close()
+	}
+
+	assert.Equal(t, 2.0, promtestutil.ToFloat64(s.metrics.RequestPanics))
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	testCfg := &RpcConfig{
+		RequestTimeout: 100 * time.Millisecond,
+		MethodTimeouts: map[string]time.Duration{
+			"test_sleep":     50 * time.Millisecond,
+			"test_cancel":    0,
+			"test_ignore":    20 * time.Millisecond,
+			"test_subscribe": 20 * time.Millisecond,
+		},
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	cancelled := make(chan error, 1)
+
+	registry := NewRegistry()
+	assert.NoError(t, registry.Register("test_sleep", func(ms int) bool {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return true
+	}))
+	assert.NoError(t, registry.Register("test_wait", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	assert.NoError(t, registry.Register("test_cancel", func(ctx context.Context) error {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	}))
+
+	subClosed := make(chan struct{})
+	assert.NoError(t, registry.Register("test_slowTicks", func(ctx context.Context) (*Subscription, error) {
+		notifier, _ := NotifierFromContext(ctx)
+		sub := notifier.CreateSubscription()
+
+		go func() {
+			<-sub.Err()
+			close(subClosed)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		return sub, nil
+	}))
+
+	var ignored atomic.Bool
+	assert.NoError(t, registry.Register("test_ignore", func() {
+		time.Sleep(200 * time.Millisecond)
+		ignored.Store(true)
+	}))
+
+	s := newTestServer(t, testCfg, registry)
+
+	// Rewrites the request after the method returns, past its timeout
+	s.Use(func(ctx context.Context, request *jsonrpc.JsonRpcRequest, next JsonRpcHandler) *jsonrpc.JsonRpcResponse {
+		response := next(ctx, request)
+		if request.Method == "test_ignore" {
+			request.Method = "test_ignored"
+		}
+		return response
+	})
+
+	addr := s.Addr().String()
+
+	for _, url := range []string{"http://" + addr, "ws://" + addr} {
+		rpcClient, err := gethrpc.Dial(url)
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		// Per-method timeout
+		var result bool
+		assert.NoError(t, rpcClient.Call(&result, "test_sleep", 10), url)
+		assert.True(t, result, url)
+
+		var rpcErr gethrpc.Error
+		err = rpcClient.Call(&result, "test_sleep", 200)
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.RequestTimeout, rpcErr.ErrorCode(), url)
+		}
+
+		// Default timeout, observed by the method through its context
+		err = rpcClient.Call(nil, "test_wait")
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.RequestTimeout, rpcErr.ErrorCode(), url)
+		}
+
+		// Client going away cancels the method
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		assert.Error(t, rpcClient.CallContext(ctx, nil, "test_cancel"), url)
+		cancel()
+
+		if url[:2] == "ws" {
+			rpcClient.Close()
+		}
+
+		select {
+		case err := <-cancelled:
+			assert.ErrorIs(t, err, context.Canceled, url)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("method was not cancelled over %s", url)
+		}
+
+		rpcClient.Close()
+	}
+
+	// Subscriptions opened past their timeout are closed right away
+	wsClient, err := gethrpc.Dial("ws://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer wsClient.Close()
+
+	_, err = wsClient.Subscribe(context.Background(), "test", make(chan int), "slowTicks")
+	var subErr gethrpc.Error
+	if assert.ErrorAs(t, err, &subErr) {
+		assert.Equal(t, jsonrpc.RequestTimeout, subErr.ErrorCode())
+	}
+
+	select {
+	case <-subClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription opened past its timeout was not closed")
+	}
+
+	// Methods ignoring their context run past their timeout, Shutdown waits for them
+	rpcClient, err := gethrpc.Dial("http://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	var rpcErr gethrpc.Error
+	if assert.ErrorAs(t, rpcClient.Call(nil, "test_ignore"), &rpcErr) {
+		assert.Equal(t, jsonrpc.RequestTimeout, rpcErr.ErrorCode())
+	}
+	assert.False(t, ignored.Load())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.True(t, ignored.Load())
+}
+
+func TestServer_Interceptors(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	api := testutils.NewMockRpcAdapter()
+
+	s := newTestServer(t, testCfg, api)
+
+	addr := s.Addr().String()
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+
+	s.Use(
+		// Auditing
+		func(ctx context.Context, request *jsonrpc.JsonRpcRequest, next JsonRpcHandler) *jsonrpc.JsonRpcResponse {
+			response := next(ctx, request)
+
+			mu.Lock()
+			seen = append(seen, fmt.Sprintf("%s:%t", request.Method, response.IsSuccess()))
+			mu.Unlock()
+
+			return response
+		},
+		// Access control
+		func(ctx context.Context, request *jsonrpc.JsonRpcRequest, next JsonRpcHandler) *jsonrpc.JsonRpcResponse {
+			if request.Method == "mock_methodB" {
+				return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, "method not allowed", nil, request.Id)
+			}
+			return next(ctx, request)
+		},
+	)
+	s.Use(
+		// Params rewriting
+		func(ctx context.Context, request *jsonrpc.JsonRpcRequest, next JsonRpcHandler) *jsonrpc.JsonRpcResponse {
+			if request.Method == "mock_methodA" {
+				request.Params = []json.RawMessage{json.RawMessage("1"), json.RawMessage("false")}
+			}
+			return next(ctx, request)
+		},
+	)
+
+	httpClient, err := gethrpc.Dial("http://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer httpClient.Close()
+
+	wsClient, err := gethrpc.Dial("ws://" + addr)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer wsClient.Close()
+
+	var result string
+	assert.NoError(t, httpClient.Call(&result, "mock_methodA", 60, true))
+	assert.Equal(t, "mock_methodA success", result)
+
+	assert.ErrorContains(t, wsClient.Call(nil, "mock_methodB", "param", false), "method not allowed")
+
+	batch := []gethrpc.BatchElem{
+		{Method: "mock_methodA", Args: []interface{}{60, true}, Result: &result},
+		{Method: "mock_methodB", Args: []interface{}{"param", false}},
+	}
+	assert.NoError(t, wsClient.BatchCall(batch))
+	assert.NoError(t, batch[0].Error)
+	assert.ErrorContains(t, batch[1].Error, "method not allowed")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	sort.Strings(seen)
+	assert.Equal(t, []string{"mock_methodA:true", "mock_methodA:true", "mock_methodB:false", "mock_methodB:false"}, seen)
+}
+
+func TestServer_IpcRequest(t *testing.T) {
+	ipcPath := filepath.Join(t.TempDir(), "rpc.ipc")
+
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+		IPC: &IpcConfig{
+			Enabled: true,
+			Path:    ipcPath,
+		},
+	}
+
+	api := &subscriptionRpcAdapter{testutils.NewMockRpcAdapter()}
+
+	s := newTestServer(t, testCfg, api)
+
+	rpcClient, err := gethrpc.DialIPC(context.Background(), ipcPath)
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Single request
+	var result string
+	assert.NoError(t, rpcClient.CallContext(ctx, &result, "mock_methodA", 1, false))
+	assert.Equal(t, "mock_methodA success", result)
+
+	// Batch request
+	batch := []gethrpc.BatchElem{
+		{Method: "mock_methodA", Args: []interface{}{1, false}, Result: new(string)},
+		{Method: "mock_methodA", Args: []interface{}{2, true}, Result: new(string)},
+	}
+	assert.NoError(t, rpcClient.BatchCallContext(ctx, batch))
+	assert.Equal(t, "mock_methodA success", *batch[0].Result.(*string))
+	assert.Error(t, batch[1].Error)
+
+	// Subscription
+	ch := make(chan uint64)
+	sub, err := rpcClient.Subscribe(ctx, "mock", ch, "counter", 3)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		select {
+		case n := <-ch:
+			assert.Equal(t, i, n)
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for notification %d", i)
+		}
+	}
+
+	sub.Unsubscribe()
+
+	// Raw newline-delimited messages
+	conn, err := net.Dial("unix", ipcPath)
+	if err != nil {
+		t.Fatalf("failed to dial ipc: %v", err)
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`{"jsonrpc":"2.0","method":"mock_methodA","params":[1,false],"id":1}{"jsonrpc":"2.0","method":"mock_methodA","params":[1,false]}` + "\n" + `{"jsonrpc":"2.0","method":"mock_unknown","id":2}`))
+	assert.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	responses := map[string]string{}
+	for i := 0; i < 2; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+
+		var response jsonrpc.JsonRpcResponse
+		assert.NoError(t, json.Unmarshal([]byte(line), &response))
+		responses[response.Id.String()] = line
+	}
+	assert.JSONEq(t, `{"jsonrpc":"2.0","result":"mock_methodA success","id":1}`, responses["1"])
+	assert.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":2}`, responses["2"])
+
+	// Malformed messages are answered before the connection is closed
+	_, err = conn.Write([]byte(`{"jsonrpc":"2.0",]` + "\n"))
+	assert.NoError(t, err)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	var response jsonrpc.JsonRpcResponse
+	assert.NoError(t, json.Unmarshal([]byte(line), &response))
+	if assert.NotNil(t, response.Error) {
+		assert.Equal(t, jsonrpc.ParseError, response.Error.Code)
+	}
+
+	_, err = reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+
+	// The socket is only accessible to its owner
+	info, err := os.Stat(ipcPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// The socket is removed on shutdown
+	s.Close()
+
+	_, err = os.Stat(ipcPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// The socket path is required
+	testCfg.IPC.Path = ""
+	_, err = NewServer(testCfg, api, nil, nil)
+	assert.ErrorIs(t, err, ErrMissingIpcPath)
+}
+
+func TestServer_Listener(t *testing.T) {
+	t.Parallel()
+
+	call := func(t *testing.T, addr net.Addr) {
+		rpcClient, err := gethrpc.Dial("http://" + addr.String())
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		defer rpcClient.Close()
+
+		var result string
+		assert.NoError(t, rpcClient.Call(&result, "mock_methodA", 1, false))
+		assert.Equal(t, "mock_methodA success", result)
+	}
+
+	testCfg := &RpcConfig{
+		Host: "127.0.0.1",
+		Port: 0,
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+	}
+
+	// A free port is picked
+	s, err := NewServer(testCfg, testutils.NewMockRpcAdapter(), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	defer s.Close()
+
+	addr := s.Addr().(*net.TCPAddr)
+	assert.Equal(t, "127.0.0.1", addr.IP.String())
+	assert.NotZero(t, addr.Port)
+
+	call(t, s.Addr())
+
+	// The listener is provided by the caller
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s2, err := NewServerWithListener(testCfg, ln, testutils.NewMockRpcAdapter(), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	assert.Equal(t, ln.Addr(), s2.Addr())
+
+	call(t, s2.Addr())
+
+	// The listener is closed on shutdown
+	s2.Close()
+
+	_, err = net.Dial("tcp", ln.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestServer_HttpRequestValidation(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled:           true,
+			MaxBodySize:       256,
+			StrictContentType: true,
+		},
+		Websocket: &WebsocketConfig{},
+	}
+
+	s := newTestServer(t, testCfg, testutils.NewMockRpcAdapter())
+
+	url := "http://" + s.Addr().String()
+	request := `{"jsonrpc":"2.0","method":"mock_methodA","params":[1,false],"id":1}`
+
+	tt := []struct {
+		name             string
+		method           string
+		contentType      string
+		body             io.Reader
+		expectedHttpCode int
+		expectedBody     string
+	}{
+		{
+			name:             "valid request",
+			method:           http.MethodPost,
+			contentType:      "application/json; charset=utf-8",
+			body:             strings.NewReader(request),
+			expectedHttpCode: http.StatusOK,
+			expectedBody:     `{"jsonrpc":"2.0","result":"mock_methodA success","id":1}`,
+		},
+		{
+			name:             "body too large",
+			method:           http.MethodPost,
+			contentType:      "application/json",
+			body:             strings.NewReader(`{"jsonrpc":"2.0","method":"mock_methodA","params":["` + strings.Repeat("a", 256) + `"],"id":1}`),
+			expectedHttpCode: http.StatusRequestEntityTooLarge,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"request too large","data":"body exceeds 256 bytes"},"id":null}`,
+		},
+		{
+			// Without Content-Length, the body is cut while being read
+			name:             "chunked body too large",
+			method:           http.MethodPost,
+			contentType:      "application/json",
+			body:             io.MultiReader(strings.NewReader(`{"jsonrpc":"2.0","method":"mock_methodA","params":["`), strings.NewReader(strings.Repeat("a", 256)+`"],"id":1}`)),
+			expectedHttpCode: http.StatusRequestEntityTooLarge,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"request too large","data":"body exceeds 256 bytes"},"id":null}`,
+		},
+		{
+			name:             "unsupported content type",
+			method:           http.MethodPost,
+			contentType:      "text/plain",
+			body:             strings.NewReader(request),
+			expectedHttpCode: http.StatusUnsupportedMediaType,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"unsupported content type","data":"text/plain"},"id":null}`,
+		},
+		{
+			name:             "trailing garbage",
+			method:           http.MethodPost,
+			contentType:      "application/json",
+			body:             strings.NewReader(request + `garbage`),
+			expectedHttpCode: http.StatusBadRequest,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid request","data":"invalid character 'g' after top-level value"},"id":null}`,
+		},
+		{
+			name:             "trailing request",
+			method:           http.MethodPost,
+			contentType:      "application/json",
+			body:             strings.NewReader(request + request),
+			expectedHttpCode: http.StatusBadRequest,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid request","data":"invalid character '{' after top-level value"},"id":null}`,
+		},
+		{
+			name:             "get with body",
+			method:           http.MethodGet,
+			contentType:      "application/json",
+			body:             strings.NewReader(request),
+			expectedHttpCode: http.StatusMethodNotAllowed,
+			expectedBody:     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"method not allowed","data":"GET"},"id":null}`,
+		},
+	}
+
+	for _, tc := range tt {
+		req, err := http.NewRequest(tc.method, url, tc.body)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", tc.contentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: failed to send request: %v", tc.name, err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err, tc.name)
+
+		assert.Equal(t, tc.expectedHttpCode, resp.StatusCode, tc.name)
+		assert.JSONEq(t, tc.expectedBody, string(body), tc.name)
+	}
+}
+
+func TestServer_HttpCompression(t *testing.T) {
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled:              true,
+			MaxBodySize:          4096,
+			Compression:          true,
+			CompressionThreshold: 512,
+		},
+		Websocket: &WebsocketConfig{},
+	}
+
+	registry := NewRegistry()
+	err := registry.Register("test_echo", func(value string) string {
+		return value
+	})
+	assert.NoError(t, err)
+
+	s := newTestServer(t, testCfg, registry)
+
+	var (
+		url         = "http://" + s.Addr().String()
+		client      = &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		largeValue  = strings.Repeat("0x1234567890abcdef", 100)
+		echoRequest = func(value string) []byte {
+			return []byte(`{"jsonrpc":"2.0","method":"test_echo","params":["` + value + `"],"id":1}`)
+		}
+	)
+
+	compress := func(encoding string, data []byte) []byte {
+		var (
+			buf bytes.Buffer
+			w   io.WriteCloser
+		)
+
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "zstd":
+			w, _ = zstd.NewWriter(&buf)
+		}
+
+		w.Write(data)
+		w.Close()
+
+		return buf.Bytes()
+	}
+
+	decompress := func(encoding string, data []byte) []byte {
+		var (
+			r   io.Reader
+			err error
+		)
+
+		switch encoding {
+		case "":
+			return data
+		case "gzip":
+			r, err = gzip.NewReader(bytes.NewReader(data))
+		case "deflate":
+			r, err = zlib.NewReader(bytes.NewReader(data))
+		case "zstd":
+			r, err = zstd.NewReader(bytes.NewReader(data))
+		}
+		assert.NoError(t, err)
+
+		decompressed, err := io.ReadAll(r)
+		assert.NoError(t, err)
+
+		return decompressed
+	}
+
+	tt := []struct {
+		name             string
+		acceptEncoding   string
+		contentEncoding  string
+		value            string
+		expectedEncoding string
+		expectedHttpCode int
+	}{
+		{
+			name:             "zstd preferred",
+			acceptEncoding:   "gzip, deflate, zstd",
+			value:            largeValue,
+			expectedEncoding: "zstd",
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "gzip",
+			acceptEncoding:   "gzip;q=0.8, zstd;q=0",
+			value:            largeValue,
+			expectedEncoding: "gzip",
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "wildcard",
+			acceptEncoding:   "gzip;q=0, *",
+			value:            largeValue,
+			expectedEncoding: "zstd",
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "all refused",
+			acceptEncoding:   "zstd;q=0, gzip;q=0, deflate;q=0, *",
+			value:            largeValue,
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "deflate",
+			acceptEncoding:   "deflate",
+			value:            largeValue,
+			expectedEncoding: "deflate",
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "unsupported encoding",
+			acceptEncoding:   "br",
+			value:            largeValue,
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "under threshold",
+			acceptEncoding:   "gzip",
+			value:            "0x1234",
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "gzip request",
+			contentEncoding:  "gzip",
+			value:            largeValue,
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "deflate request",
+			contentEncoding:  "deflate",
+			value:            largeValue,
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "zstd request and response",
+			acceptEncoding:   "zstd",
+			contentEncoding:  "zstd",
+			value:            largeValue,
+			expectedEncoding: "zstd",
+			expectedHttpCode: http.StatusOK,
+		},
+		{
+			name:             "decompressed request too large",
+			contentEncoding:  "gzip",
+			value:            strings.Repeat("0", 8192),
+			expectedHttpCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range tt {
+		body := echoRequest(tc.value)
+		if tc.contentEncoding != "" {
+			body = compress(tc.contentEncoding, body)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		req.Header.Set("Content-Encoding", tc.contentEncoding)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: failed to send request: %v", tc.name, err)
+		}
+
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err, tc.name)
+
+		assert.Equal(t, tc.expectedHttpCode, resp.StatusCode, tc.name)
+		assert.Equal(t, tc.expectedEncoding, resp.Header.Get("Content-Encoding"), tc.name)
+
+		if tc.expectedHttpCode == http.StatusOK {
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), tc.name)
+			assert.JSONEq(t, `{"jsonrpc":"2.0","result":"`+tc.value+`","id":1}`, string(decompress(tc.expectedEncoding, respBody)), tc.name)
+		}
+	}
+
+	// Unknown request encodings are rejected
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(echoRequest("0x")))
+	req.Header.Set("Content-Encoding", "br")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	// Ratios are observed by encoding
+	assert.Equal(t, 3, promtestutil.CollectAndCount(s.metrics.RequestCompressionRatio))
+	assert.Equal(t, 3, promtestutil.CollectAndCount(s.metrics.ResponseCompressionRatio))
+}
+
+func TestServer_EncodingErrors(t *testing.T) {
+	ipcPath := filepath.Join(t.TempDir(), "rpc.ipc")
+
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+		IPC: &IpcConfig{
+			Enabled: true,
+			Path:    ipcPath,
+		},
+	}
+
+	registry := NewRegistry()
+	err := registry.Register("test_nan", func() float64 {
+		return math.NaN()
+	})
+	assert.NoError(t, err)
+	err = registry.Register("test_trace", func(n int) []string {
+		trace := make([]string, n)
+		for i := range trace {
+			trace[i] = fmt.Sprintf("0x%064x", i)
+		}
+		return trace
+	})
+	assert.NoError(t, err)
+	err = registry.Register("test_panic", func() panickingResult {
+		return panickingResult{}
+	})
+	assert.NoError(t, err)
+
+	s := newTestServer(t, testCfg, registry)
+
+	for _, url := range []string{"http://" + s.Addr().String(), "ws://" + s.Addr().String(), ipcPath} {
+		rpcClient, err := gethrpc.Dial(url)
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		// Results that can't be encoded are turned into internal errors
+		var result float64
+		err = rpcClient.Call(&result, "test_nan")
+
+		var rpcErr gethrpc.Error
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.InternalError, rpcErr.ErrorCode(), url)
+		}
+
+		// As are results panicking when encoded, the connection is kept
+		err = rpcClient.Call(nil, "test_panic")
+		if assert.ErrorAs(t, err, &rpcErr, url) {
+			assert.Equal(t, jsonrpc.InternalError, rpcErr.ErrorCode(), url)
+		}
+
+		// Other responses of the batch are not affected
+		batch := []gethrpc.BatchElem{
+			{Method: "test_nan", Result: new(float64)},
+			{Method: "test_trace", Args: []interface{}{10000}, Result: new([]string)},
+		}
+		assert.NoError(t, rpcClient.BatchCall(batch), url)
+		assert.Error(t, batch[0].Error, url)
+		assert.NoError(t, batch[1].Error, url)
+		assert.Len(t, *batch[1].Result.(*[]string), 10000, url)
+
+		rpcClient.Close()
+	}
+}
+
+type discoveryNode struct {
+	Value    int              `json:"value"`
+	Label    string           `json:"label,omitempty"`
+	Children []*discoveryNode `json:"children"`
+}
+
+type optional_discoveryOptions map[string]interface{}
+
+type panickingResult struct{}
+
+func (panickingResult) MarshalJSON() ([]byte, error) {
+	panic("failed to encode")
+}
+
+func TestServer_Discovery(t *testing.T) {
+	t.Parallel()
+
+	registry := NewRegistry()
+	assert.NoError(t, registry.Register("test_walk", func(ctx context.Context, root discoveryNode, depth uint64, opts optional_discoveryOptions) ([]discoveryNode, error) {
+		return nil, nil
+	}, WithParamNames("root", "depth", "opts"), WithDescription("Walks the tree"), WithParamDescriptions("Root of the tree", "Maximum depth", "Options")))
+	assert.NoError(t, registry.Register("test_pair", func(data []byte) (string, bool) {
+		return "", false
+	}))
+	assert.NoError(t, registry.Register("test_noop", func() error {
+		return nil
+	}))
+	assert.NoError(t, registry.Register("test_ticks", func(ctx context.Context) (*Subscription, error) {
+		return nil, nil
+	}))
+
+	// Descriptions must match the arguments
+	assert.Error(t, registry.Register("test_invalid", func(a, b int) {}, WithParamDescriptions("a")))
+
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Discovery: &DiscoveryConfig{
+			Enabled: true,
+			Title:   "Test API",
+		},
+	}
+
+	s := newTestServer(t, testCfg, registry)
+
+	rpcClient, err := gethrpc.Dial("http://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient.Close()
+
+	var doc openrpcDocument
+	assert.NoError(t, rpcClient.Call(&doc, discoverMethod))
+
+	assert.Equal(t, openrpcVersion, doc.OpenRPC)
+	assert.Equal(t, "Test API", doc.Info.Title)
+	assert.Equal(t, defaultDiscoveryVersion, doc.Info.Version)
+
+	methods := make(map[string]*openrpcMethod)
+	for _, method := range doc.Methods {
+		methods[method.Name] = method
+	}
+	assert.Len(t, methods, 3)
+
+	// Context is hidden, names and descriptions are the registered ones, the optional param is not required
+	walk := methods["test_walk"]
+	assert.Equal(t, "Walks the tree", walk.Description)
+	assert.Empty(t, walk.ParamStructure)
+	if assert.Len(t, walk.Params, 3) {
+		assert.Equal(t, "root", walk.Params[0].Name)
+		assert.Equal(t, "Root of the tree", walk.Params[0].Description)
+		assert.True(t, walk.Params[0].Required)
+		assert.Equal(t, schemaRefPrefix+"discoveryNode", walk.Params[0].Schema.Ref)
+		assert.Equal(t, "integer", walk.Params[1].Schema.Type)
+		assert.False(t, walk.Params[2].Required)
+		assert.Equal(t, "object", walk.Params[2].Schema.Type)
+	}
+	assert.Equal(t, "array", walk.Result.Schema.Type)
+
+	// Named structs are described once, recursive fields refer to their component
+	if assert.NotNil(t, doc.Components) {
+		node := doc.Components.Schemas["discoveryNode"]
+		if assert.NotNil(t, node) {
+			assert.Equal(t, "object", node.Type)
+			assert.Equal(t, []string{"value", "children"}, node.Required)
+			assert.Equal(t, "integer", node.Properties["value"].Type)
+			assert.Equal(t, "string", node.Properties["label"].Type)
+			assert.Equal(t, "array", node.Properties["children"].Type)
+			assert.Equal(t, map[string]interface{}{"$ref": schemaRefPrefix + "discoveryNode"}, node.Properties["children"].Items)
+		}
+	}
+
+	// Params without names are by-position, multiple results are a tuple
+	pair := methods["test_pair"]
+	assert.Equal(t, "by-position", pair.ParamStructure)
+	if assert.Len(t, pair.Params, 1) {
+		assert.Equal(t, "param1", pair.Params[0].Name)
+		assert.Equal(t, "string", pair.Params[0].Schema.Type)
+		assert.Equal(t, "base64", pair.Params[0].Schema.ContentEncoding)
+	}
+	assert.Equal(t, "array", pair.Result.Schema.Type)
+	assert.Len(t, pair.Result.Schema.Items, 2)
+
+	assert.Empty(t, methods["test_noop"].Params)
+	assert.Equal(t, "string", methods["test_noop"].Result.Schema.Type)
+
+	// Subscriptions are only listed on the transports supporting them
+	assert.NotContains(t, methods, "test_ticks")
+
+	var (
+		subCtx, _ = withSubscriptions(context.Background(), nil)
+		ticks     *openrpcMethod
+	)
+	for _, method := range s.openrpcDocument(subCtx).Methods {
+		if method.Name == "test_ticks" {
+			ticks = method
+		}
+	}
+	if assert.NotNil(t, ticks) {
+		if assert.Len(t, ticks.Tags, 1) {
+			assert.Equal(t, "subscription", ticks.Tags[0].Name)
+		}
+		assert.Equal(t, "subscriptionId", ticks.Result.Name)
+	}
+
+	// The same document is served over GET
+	resp, err := http.Get("http://" + s.Addr().String() + defaultDiscoveryEndpoint)
+	if err != nil {
+		t.Fatalf("failed to get document: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var getDoc openrpcDocument
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&getDoc))
+	assert.Equal(t, doc, getDoc)
+
+	// Reflection based APIs are described from the methods of their type
+	s2 := newTestServer(t, testCfg, testutils.NewMockRpcAdapter())
+
+	doc2 := s2.openrpcDocument(context.Background())
+
+	var names []string
+	for _, method := range doc2.Methods {
+		names = append(names, method.Name)
+		if method.Name == "mock_methodA" {
+			assert.Equal(t, "param1", method.Params[0].Name)
+			assert.Equal(t, "shouldError", method.Params[1].Name)
+		}
+		if method.Name == "mock_methodWithContext" {
+			assert.Len(t, method.Params, 1)
+		}
+	}
+	assert.Contains(t, names, "mock_methodA")
+	assert.Contains(t, names, "mock_methodWithContext")
+	assert.NotContains(t, names, "runtimeMethod")
+	assert.NotContains(t, names, "paramNames")
+
+	// Discovery is disabled by default
+	s3 := newTestServer(t, &RpcConfig{HTTP: &HttpConfig{Enabled: true}}, registry)
+
+	rpcClient3, err := gethrpc.Dial("http://" + s3.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create rpc client: %v", err)
+	}
+
+	defer rpcClient3.Close()
+
+	var rpcErr gethrpc.Error
+	assert.ErrorAs(t, rpcClient3.Call(&doc, discoverMethod), &rpcErr)
+	assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.ErrorCode())
+}
+
+func TestServer_Modules(t *testing.T) {
+	t.Parallel()
+
+	ipcPath := filepath.Join(t.TempDir(), "rpc.ipc")
+
+	registry := NewRegistry()
+	for _, method := range []string{"eth_blockNumber", "admin_peers", "debug_traceCall"} {
+		method := method
+		assert.NoError(t, registry.Register(method, func() string {
+			return method
+		}))
+	}
+
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+			Modules: []string{"eth"},
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+			Modules: []string{"eth", "debug"},
+		},
+		IPC: &IpcConfig{
+			Enabled: true,
+			Path:    ipcPath,
+			Modules: []string{"admin"},
+		},
+		Discovery: &DiscoveryConfig{
+			Enabled: true,
+		},
+		ModuleVersions: map[string]string{
+			"eth": "2.0",
+		},
+	}
+
+	s := newTestServer(t, testCfg, registry)
+
+	tests := []struct {
+		url     string
+		modules map[string]string
+		methods []string
+	}{
+		{
+			url:     "http://" + s.Addr().String(),
+			modules: map[string]string{"rpc": "1.0", "eth": "2.0"},
+			methods: []string{"eth_blockNumber"},
+		},
+		{
+			url:     "ws://" + s.Addr().String(),
+			modules: map[string]string{"rpc": "1.0", "eth": "2.0", "debug": "1.0"},
+			methods: []string{"debug_traceCall", "eth_blockNumber"},
+		},
+		{
+			url:     ipcPath,
+			modules: map[string]string{"rpc": "1.0", "admin": "1.0"},
+			methods: []string{"admin_peers"},
+		},
+	}
+
+	for _, tt := range tests {
+		rpcClient, err := gethrpc.Dial(tt.url)
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		var modules map[string]string
+		assert.NoError(t, rpcClient.Call(&modules, "rpc_modules"), tt.url)
+		assert.Equal(t, tt.modules, modules, tt.url)
+
+		// Methods of disabled namespaces are not found
+		for _, method := range registry.Methods() {
+			var result string
+			err := rpcClient.Call(&result, method)
+
+			var rpcErr gethrpc.Error
+			if slices.Contains(tt.methods, method) {
+				assert.NoError(t, err, tt.url, method)
+				assert.Equal(t, method, result, tt.url)
+			} else if assert.ErrorAs(t, err, &rpcErr, tt.url, method) {
+				assert.Equal(t, jsonrpc.MethodNotFound, rpcErr.ErrorCode(), tt.url, method)
+			}
+		}
+
+		// Discovery only lists the enabled methods
+		var doc openrpcDocument
+		assert.NoError(t, rpcClient.Call(&doc, discoverMethod), tt.url)
+
+		var names []string
+		for _, method := range doc.Methods {
+			names = append(names, method.Name)
+		}
+		assert.Equal(t, tt.methods, names, tt.url)
+
+		rpcClient.Close()
+	}
+}
@@ -0,0 +1,344 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+)
+
+const (
+	subscribeMethodSuffix    = "_subscribe"
+	unsubscribeMethodSuffix  = "_unsubscribe"
+	notificationMethodSuffix = "_subscription"
+)
+
+var (
+	ErrNotificationsUnsupported = errors.New("notifications not supported")
+	ErrSubscriptionNotFound     = errors.New("subscription not found")
+)
+
+type notifierContextKey struct{}
+type subscriptionScopeContextKey struct{}
+
+type SubscriptionId string
+
+func newSubscriptionId() SubscriptionId {
+	var id [16]byte
+	rand.Read(id[:])
+	return SubscriptionId("0x" + hex.EncodeToString(id[:]))
+}
+
+// Subscription is created by a subscription method through its Notifier, and must be returned by
+// the method for the subscription to be opened.
+type Subscription struct {
+	ID        SubscriptionId
+	namespace string
+	err       chan error
+	closeOnce sync.Once
+}
+
+// Err returns a channel that is closed when the client unsubscribes or the connection is closed.
+func (s *Subscription) Err() <-chan error {
+	return s.err
+}
+
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.err)
+	})
+}
+
+// Notifier is passed to subscription methods through their context, it creates the subscription
+// and pushes notifications to the client.
+//
+// Notifications sent before the subscription id has been sent back to the client are buffered.
+type Notifier struct {
+	registry  *subscriptionRegistry
+	namespace string
+
+	mu        sync.Mutex
+	sub       *Subscription
+	activated bool
+	buffer    []interface{}
+}
+
+// NotifierFromContext returns the Notifier of a subscription method. It is only available to methods
+// called through "<namespace>_subscribe" on transports supporting notifications.
+func NotifierFromContext(ctx context.Context) (*Notifier, bool) {
+	n, ok := ctx.Value(notifierContextKey{}).(*Notifier)
+	return n, ok
+}
+
+// CreateSubscription returns the subscription of the notifier, creating it on first call.
+func (n *Notifier) CreateSubscription() *Subscription {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.sub == nil {
+		n.sub = &Subscription{
+			ID:        newSubscriptionId(),
+			namespace: n.namespace,
+			err:       make(chan error),
+		}
+	}
+
+	return n.sub
+}
+
+// Notify sends a notification carrying data to the client.
+func (n *Notifier) Notify(id SubscriptionId, data interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.sub == nil || n.sub.ID != id {
+		return ErrSubscriptionNotFound
+	}
+
+	if !n.activated {
+		n.buffer = append(n.buffer, data)
+		return nil
+	}
+
+	return n.registry.notify(n.sub, data)
+}
+
+// activate flushes the buffered notifications, all later notifications are sent right away.
+func (n *Notifier) activate() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.sub != nil {
+		for _, data := range n.buffer {
+			n.registry.notify(n.sub, data)
+		}
+	}
+
+	n.buffer = nil
+	n.activated = true
+}
+
+// subscriptionRegistry holds the active subscriptions of a connection.
+type subscriptionRegistry struct {
+	send    func(msg []byte)
+	metrics *RpcMetrics
+
+	mu     sync.Mutex
+	subs   map[SubscriptionId]*Subscription
+	closed bool
+}
+
+func newSubscriptionRegistry(send func(msg []byte), metrics *RpcMetrics) *subscriptionRegistry {
+	return &subscriptionRegistry{
+		send:    send,
+		metrics: metrics,
+		subs:    make(map[SubscriptionId]*Subscription),
+	}
+}
+
+func (r *subscriptionRegistry) add(sub *Subscription) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+
+	r.subs[sub.ID] = sub
+
+	if r.metrics.enabled {
+		r.metrics.Subscriptions.Inc()
+	}
+
+	return true
+}
+
+// remove ends the subscription with the given id, provided it was opened in namespace.
+func (r *subscriptionRegistry) remove(namespace string, id SubscriptionId) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub, ok := r.subs[id]
+	if !ok || sub.namespace != namespace {
+		return false
+	}
+
+	delete(r.subs, id)
+	sub.close()
+
+	if r.metrics.enabled {
+		r.metrics.Subscriptions.Dec()
+	}
+
+	return true
+}
+
+func (r *subscriptionRegistry) notify(sub *Subscription, data interface{}) error {
+	r.mu.Lock()
+	_, ok := r.subs[sub.ID]
+	r.mu.Unlock()
+
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+
+	// Encoded right away so that data the client can't receive is reported to the caller
+	msg, err := json.Marshal(jsonrpc.NewJsonRpcSubscriptionNotification(sub.namespace+notificationMethodSuffix, string(sub.ID), data))
+	if err != nil {
+		return err
+	}
+
+	r.send(msg)
+	return nil
+}
+
+// closeAll ends all subscriptions, it is called when the connection is closed.
+func (r *subscriptionRegistry) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, sub := range r.subs {
+		delete(r.subs, id)
+		sub.close()
+
+		if r.metrics.enabled {
+			r.metrics.Subscriptions.Dec()
+		}
+	}
+
+	r.closed = true
+}
+
+// subscriptionScope collects the notifiers created while serving an incoming message.
+type subscriptionScope struct {
+	registry *subscriptionRegistry
+
+	mu        sync.Mutex
+	notifiers []*Notifier
+}
+
+// withSubscriptions enables subscriptions for the requests served with the returned context. The returned
+// function must be called once the response has been queued: it activates the subscriptions created while
+// serving the request, so that no notification is sent ahead of the subscription id.
+func withSubscriptions(ctx context.Context, registry *subscriptionRegistry) (context.Context, func()) {
+	scope := &subscriptionScope{registry: registry}
+
+	activate := func() {
+		scope.mu.Lock()
+		defer scope.mu.Unlock()
+
+		for _, n := range scope.notifiers {
+			n.activate()
+		}
+		scope.notifiers = nil
+	}
+
+	return context.WithValue(ctx, subscriptionScopeContextKey{}, scope), activate
+}
+
+// subscriptionsSupported reports whether the transport of the request supports subscriptions.
+func subscriptionsSupported(ctx context.Context) bool {
+	_, ok := ctx.Value(subscriptionScopeContextKey{}).(*subscriptionScope)
+	return ok
+}
+
+func (s *Server) handleSubscribe(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
+	scope, ok := ctx.Value(subscriptionScopeContextKey{}).(*subscriptionScope)
+	if !ok {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, ErrNotificationsUnsupported.Error(), nil, request.Id)
+	}
+
+	// The subscription id is the response, a notification would open a subscription nobody knows about
+	if request.IsNotification() {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidRequest, "invalid request", "subscribe requires an id", request.Id)
+	}
+
+	if request.IsByName() {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", "by-name params not supported", request.Id)
+	}
+
+	if len(request.Params) == 0 {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", "subscription name expected", request.Id)
+	}
+
+	var name string
+	if err := json.Unmarshal(request.Params[0], &name); err != nil {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", "subscription name must be a string", request.Id)
+	}
+
+	namespace := strings.TrimSuffix(request.Method, subscribeMethodSuffix)
+	notifier := &Notifier{
+		registry:  scope.registry,
+		namespace: namespace,
+	}
+
+	subRequest := &jsonrpc.JsonRpcRequest{
+		Version: request.Version,
+		Method:  namespace + "_" + name,
+		Params:  request.Params[1:],
+		Id:      request.Id,
+	}
+
+	if response, rejected := s.rateLimitSubscription(ctx, subRequest); rejected {
+		return response
+	}
+
+	response := s._handleJsonRpcRequest(context.WithValue(ctx, notifierContextKey{}, notifier), subRequest)
+	if !response.IsSuccess() {
+		return response
+	}
+
+	sub, ok := response.Result.(*Subscription)
+	if !ok || sub != notifier.sub {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", "method did not return its subscription", request.Id)
+	}
+
+	// Timed out or cancelled, the client won't learn the subscription id
+	if ctx.Err() != nil {
+		sub.close()
+		return contextErrorResponse(ctx, request.Id)
+	}
+
+	if !scope.registry.add(sub) {
+		sub.close()
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", "connection closed", request.Id)
+	}
+
+	scope.mu.Lock()
+	scope.notifiers = append(scope.notifiers, notifier)
+	scope.mu.Unlock()
+
+	return jsonrpc.NewJsonRpcSuccessResponse(sub.ID, request.Id)
+}
+
+func (s *Server) handleUnsubscribe(ctx context.Context, request *jsonrpc.JsonRpcRequest) *jsonrpc.JsonRpcResponse {
+	scope, ok := ctx.Value(subscriptionScopeContextKey{}).(*subscriptionScope)
+	if !ok {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.MethodNotFound, ErrNotificationsUnsupported.Error(), nil, request.Id)
+	}
+
+	if request.IsByName() {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", "by-name params not supported", request.Id)
+	}
+
+	if len(request.Params) != 1 {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params count", nil, request.Id)
+	}
+
+	var id string
+	if err := json.Unmarshal(request.Params[0], &id); err != nil {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, "invalid params", "subscription id must be a string", request.Id)
+	}
+
+	namespace := strings.TrimSuffix(request.Method, unsubscribeMethodSuffix)
+	if !scope.registry.remove(namespace, SubscriptionId(id)) {
+		return jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InvalidParams, ErrSubscriptionNotFound.Error(), nil, request.Id)
+	}
+
+	return jsonrpc.NewJsonRpcSuccessResponse(true, request.Id)
+}
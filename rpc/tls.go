@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/log"
+)
+
+const (
+	defaultCertReloadInterval = 10 * time.Second
+)
+
+var (
+	ErrInvalidClientCA = errors.New("no certificate found in client CA file")
+)
+
+// certReloader serves the certificate and the client CA loaded from disk, reloading them when the files
+// change.
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+}
+
+func newCertReloader(certFile, keyFile, clientCAFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// configForClient returns the GetConfigForClient callback of base, verifying the certificates of new
+// connections with the current client CA.
+func (r *certReloader) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = r.clientCAs
+
+		return cfg, nil
+	}
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// lastModTime returns the latest modification time of the certificate, key and client CA files.
+func (r *certReloader) lastModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// reload loads the certificate and the client CA, the current ones are kept if either can't be loaded.
+func (r *certReloader) reload() error {
+	modTime, err := r.lastModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed loading TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		if clientCAs, err = loadCertPool(r.clientCAFile); err != nil {
+			return fmt.Errorf("failed loading client CA: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTime = modTime
+
+	return nil
+}
+
+// watch polls the certificate and client CA files and reloads them when modified, until stopChan is
+// closed. The current certificate and client CA are kept if the new ones can't be loaded.
+func (r *certReloader) watch(interval time.Duration, stopChan <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+
+		case <-ticker.C:
+			modTime, err := r.lastModTime()
+			if err != nil {
+				log.Error(context.Background(), "failed checking TLS certificate", "err", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := r.reload(); err != nil {
+				log.Error(context.Background(), "failed reloading TLS certificate", "err", err)
+				continue
+			}
+
+			log.Info(context.Background(), "TLS certificate reloaded", "cert", r.certFile, "clientCA", r.clientCAFile)
+		}
+	}
+}
+
+// loadCertPool reads the PEM encoded certificates of file.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrInvalidClientCA
+	}
+
+	return pool, nil
+}
+
+// buildTLSConfig builds the TLS configuration of the listener, along with the reloader serving its certificate
+// and client CA.
+func buildTLSConfig(cfg *TLSConfig) (*tls.Config, *certReloader, error) {
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		// Websockets are upgraded from HTTP/1.1 connections
+		NextProtos: []string{"http/1.1"},
+	}
+
+	if cfg.ClientCAFile != "" {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.GetConfigForClient = reloader.configForClient(tlsConfig.Clone())
+	}
+
+	return tlsConfig, reloader, nil
+}
@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert issues a certificate for commonName, self-signed if parent is nil.
+func newTestCert(t *testing.T, commonName string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+
+	if keyFile == "" {
+		return
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.cert.Raw},
+		PrivateKey:  c.key,
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	var (
+		dir          = t.TempDir()
+		certFile     = filepath.Join(dir, "server.crt")
+		keyFile      = filepath.Join(dir, "server.key")
+		clientCAFile = filepath.Join(dir, "ca.crt")
+	)
+
+	ca := newTestCert(t, "test-ca", nil, true)
+	ca.write(t, clientCAFile, "")
+	newTestCert(t, "server-1", ca, false).write(t, certFile, keyFile)
+
+	testCfg := &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: &WebsocketConfig{
+			Enabled: true,
+		},
+		TLS: &TLSConfig{
+			Enabled:        true,
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			ClientCAFile:   clientCAFile,
+			ReloadInterval: 50 * time.Millisecond,
+		},
+	}
+
+	registry := NewRegistry()
+	err := registry.Register("test_whoami", func(ctx context.Context) string {
+		subject, _ := rpcContext.ClientCertSubjectFromContext(ctx)
+		return subject
+	})
+	assert.NoError(t, err)
+
+	s := newTestServer(t, testCfg, registry)
+
+	addr := s.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	clientTLSConfig := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{newTestCert(t, "searcher-1", ca, false).tlsCertificate()},
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+	wsDialer := &websocket.Dialer{TLSClientConfig: clientTLSConfig}
+
+	// The verified client certificate subject is exposed to methods
+	for _, url := range []string{"https://" + addr, "wss://" + addr} {
+		rpcClient, err := gethrpc.DialOptions(context.Background(), url, gethrpc.WithHTTPClient(httpClient), gethrpc.WithWebsocketDialer(*wsDialer))
+		if err != nil {
+			t.Fatalf("failed to create rpc client: %v", err)
+		}
+
+		var subject string
+		assert.NoError(t, rpcClient.Call(&subject, "test_whoami"), url)
+		assert.Equal(t, "CN=searcher-1", subject, url)
+
+		rpcClient.Close()
+	}
+
+	// Clients without certificate are rejected
+	noCertClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	_, err = noCertClient.Get("https://" + addr)
+	assert.Error(t, err)
+
+	// The server certificate is reloaded when modified
+	newTestCert(t, "server-2", ca, false).write(t, certFile, keyFile)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+
+	assert.Eventually(t, func() bool {
+		conn, err := tls.Dial("tcp", addr, clientTLSConfig)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName == "server-2"
+	}, 2*time.Second, 50*time.Millisecond)
+
+	// The client CA is reloaded when modified
+	ca2 := newTestCert(t, "test-ca-2", nil, true)
+	ca2.write(t, clientCAFile, "")
+	later = later.Add(time.Second)
+	os.Chtimes(clientCAFile, later, later)
+
+	rotatedTLSConfig := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{newTestCert(t, "searcher-2", ca2, false).tlsCertificate()},
+	}
+
+	handshake := func(tlsConfig *tls.Config) error {
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		// Client certificates are verified by the server after the client handshake completes
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		return err
+	}
+
+	assert.Eventually(t, func() bool {
+		return handshake(rotatedTLSConfig) == nil
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Error(t, handshake(clientTLSConfig))
+}
@@ -5,6 +5,6 @@ import (
 	"reflect"
 )
 
-func formatConversionErrMsg(i int, call *reflect.Value) string {
-	return fmt.Sprintf("Param [%d] can't be converted to %s", i, call.Type().In(i).Name())
+func formatConversionErrMsg(i int, t reflect.Type) string {
+	return fmt.Sprintf("Param [%d] can't be converted to %s", i, t.String())
 }
@@ -2,88 +2,274 @@ package rpc
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
-	"runtime/debug"
 	"time"
 
 	"github.com/FastLane-Labs/fastlane-json-rpc/log"
-	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
-	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 const (
-	pongWait   = 60 * time.Second
-	pingPeriod = (60 * time.Second * 9) / 10
-	writeWait  = 2 * time.Second
+	closeGracePeriod = time.Second
 )
 
 type Conn struct {
 	*websocket.Conn
 	IP       string
 	sendChan chan []byte
+	doneChan chan struct{}
+
+	// Parent of the request contexts, cancelled when the connection closes or the server shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	subscriptions *subscriptionRegistry
+
+	// Host of the remote address the connection is counted against
+	host string
+
+	// Slots of the requests being served, nil when unlimited
+	inFlight chan struct{}
+
+	slowConsumerPolicy  SlowConsumerPolicy
+	slowConsumerTimeout time.Duration
+	metrics             *RpcMetrics
 }
 
 func NewConn(conn *websocket.Conn) *Conn {
-	return &Conn{
-		Conn:     conn,
-		IP:       conn.RemoteAddr().String(),
-		sendChan: make(chan []byte, 256),
+	return newConn(context.Background(), conn, &WebsocketConfig{}, NewRpcMetrics(nil))
+}
+
+func newConn(parent context.Context, conn *websocket.Conn, cfg *WebsocketConfig, metrics *RpcMetrics) *Conn {
+	ctx, cancel := context.WithCancel(parent)
+	policy, _ := cfg.slowConsumerPolicy()
+
+	c := &Conn{
+		Conn:                conn,
+		IP:                  conn.RemoteAddr().String(),
+		sendChan:            make(chan []byte, cfg.sendBufferSize()),
+		doneChan:            make(chan struct{}),
+		ctx:                 ctx,
+		cancel:              cancel,
+		slowConsumerPolicy:  policy,
+		slowConsumerTimeout: cfg.SlowConsumerTimeout,
+		metrics:             metrics,
+	}
+
+	if cfg.MaxInFlight > 0 {
+		c.inFlight = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	if cfg.MaxMessageSize > 0 {
+		conn.SetReadLimit(cfg.MaxMessageSize)
+	}
+
+	return c
+}
+
+// sendRaw queues msg to the write loop, it is dropped if the connection is closed. When the send buffer
+// is full, the slow consumer policy of the connection applies.
+func (c *Conn) sendRaw(msg []byte) {
+	select {
+	case c.sendChan <- msg:
+		return
+	case <-c.doneChan:
+		return
+	default:
+	}
+
+	switch c.slowConsumerPolicy {
+	case SlowConsumerDrop:
+		c.slowConsumer("dropped")
+		log.Warn(c.ctx, "websocket send buffer full, message dropped", "ip", c.IP)
+
+	case SlowConsumerDisconnect:
+		c.slowConsumer("disconnected")
+		c.disconnect()
+
+	default:
+		var timeout <-chan time.Time
+		if c.slowConsumerTimeout > 0 {
+			timer := time.NewTimer(c.slowConsumerTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case c.sendChan <- msg:
+			c.slowConsumer("blocked")
+		case <-c.doneChan:
+		case <-timeout:
+			c.slowConsumer("disconnected")
+			c.disconnect()
+		}
+	}
+}
+
+func (c *Conn) slowConsumer(action string) {
+	if c.metrics.enabled {
+		c.metrics.WebsocketSlow.WithLabelValues(action).Inc()
+	}
+}
+
+// disconnect closes the underlying connection, the read loop then releases the connection.
+func (c *Conn) disconnect() {
+	log.Warn(c.ctx, "disconnecting slow websocket consumer", "ip", c.IP)
+
+	c.cancel()
+	c.Conn.Close()
+}
+
+// acquireInFlight waits for a request slot, it returns false if the connection closes first.
+func (c *Conn) acquireInFlight() bool {
+	if c.inFlight == nil {
+		return true
+	}
+
+	select {
+	case c.inFlight <- struct{}{}:
+		return true
+	default:
+	}
+
+	if c.metrics.enabled {
+		c.metrics.WebsocketThrottled.Inc()
+	}
+
+	select {
+	case c.inFlight <- struct{}{}:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+func (c *Conn) releaseInFlight() {
+	if c.inFlight != nil {
+		<-c.inFlight
 	}
 }
 
-func (c *Conn) send(msg *jsonrpc.JsonRpcResponse) {
-	c.sendChan <- msg.Marshal()
+// acquireWebsocketSlot counts a new connection from host against the connection limits. It returns the
+// HTTP status to reject the connection with if a limit is reached, 0 otherwise.
+func (s *Server) acquireWebsocketSlot(host string) int {
+	s.wsConnsMu.Lock()
+	defer s.wsConnsMu.Unlock()
+
+	var (
+		status int
+		reason string
+	)
+
+	switch {
+	case s.cfg.Websocket.MaxConnections > 0 && s.wsConns >= s.cfg.Websocket.MaxConnections:
+		status, reason = http.StatusServiceUnavailable, "max_connections"
+	case s.cfg.Websocket.MaxConnectionsPerIP > 0 && s.wsConnsPerIP[host] >= s.cfg.Websocket.MaxConnectionsPerIP:
+		status, reason = http.StatusTooManyRequests, "max_connections_per_ip"
+	default:
+		if s.wsConnsPerIP == nil {
+			s.wsConnsPerIP = make(map[string]int)
+		}
+
+		s.wsConns++
+		s.wsConnsPerIP[host]++
+		return 0
+	}
+
+	if s.metrics.enabled {
+		s.metrics.WebsocketRejected.WithLabelValues(reason).Inc()
+	}
+
+	return status
+}
+
+func (s *Server) releaseWebsocketSlot(host string) {
+	s.wsConnsMu.Lock()
+	defer s.wsConnsMu.Unlock()
+
+	s.wsConns--
+	if s.wsConnsPerIP[host]--; s.wsConnsPerIP[host] <= 0 {
+		delete(s.wsConnsPerIP, host)
+	}
+}
+
+func (s *Server) websocketUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:    s.cfg.Websocket.ReadBufferSize,
+		WriteBufferSize:   s.cfg.Websocket.WriteBufferSize,
+		EnableCompression: s.cfg.Websocket.EnableCompression,
+		Subprotocols:      s.cfg.Websocket.Subprotocols,
+		CheckOrigin:       s.cfg.Websocket.checkOrigin,
+	}
 }
 
 func (s *Server) websocketHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	s.wg.Add(1)
 	defer s.wg.Done()
 
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+	host := remoteHost(r)
+
+	if status := s.acquireWebsocketSlot(host); status != 0 {
+		log.Warn(ctx, "websocket connection rejected", "ip", r.RemoteAddr, "status", status)
+		http.Error(w, http.StatusText(status), status)
+		return
 	}
 
-	c, err := upgrader.Upgrade(w, r, nil)
+	c, err := s.websocketUpgrader().Upgrade(w, r, nil)
 	if err != nil {
+		s.releaseWebsocketSlot(host)
 		log.Error(ctx, "failed upgrading connection", "err", err)
 		return
 	}
 
-	conn := NewConn(c)
-	doneChan := make(chan struct{})
+	conn := newConn(ctx, c, s.cfg.Websocket, s.metrics)
+	conn.host = host
+	conn.subscriptions = newSubscriptionRegistry(conn.sendRaw, s.metrics)
 
-	go s.websocketWriteLoop(conn, doneChan)
-	go s.websocketReadLoop(conn, doneChan)
+	s.wg.Add(2)
+	go s.websocketWriteLoop(conn)
+	go s.websocketReadLoop(conn)
 
 	if s.metrics.enabled {
 		s.metrics.WebsocketConnections.Inc()
 	}
 }
 
-func (s *Server) websocketReadLoop(conn *Conn, doneChan chan struct{}) {
+func (s *Server) websocketReadLoop(conn *Conn) {
+	defer s.wg.Done()
 	defer func() {
+		conn.cancel()
+		conn.subscriptions.closeAll()
 		conn.Close()
-		close(doneChan)
+		close(conn.doneChan)
+		s.releaseWebsocketSlot(conn.host)
 
 		if s.metrics.enabled {
 			s.metrics.WebsocketConnections.Dec()
 		}
 	}()
 
-	conn.SetReadDeadline(time.Now().Add(pongWait))
+	pongTimeout := s.cfg.Websocket.pongTimeout()
+
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
 	conn.SetPongHandler(func(appData string) error {
-		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
 		return nil
 	})
 
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				if s.metrics.enabled {
+					s.metrics.WebsocketOversized.Inc()
+				}
+
+				log.Warn(conn.ctx, "websocketReadLoop: message too large", "ip", conn.IP)
+				return
+			}
+
 			if websocket.IsUnexpectedCloseError(
 				err,
 				websocket.CloseGoingAway,
@@ -96,60 +282,49 @@ func (s *Server) websocketReadLoop(conn *Conn, doneChan chan struct{}) {
 			return
 		}
 
-		// Handle the request in a separate goroutine
-		go func() {
-			ctx := rpcContext.NewContextWithTraceId(context.Background(), uuid.New().String())
-
-			defer func() {
-				if r := recover(); r != nil {
-					conn.send(jsonrpc.NewJsonRpcErrorResponse(jsonrpc.InternalError, "internal error", nil, nil))
-					log.Error(ctx, "websocket server execution error", "error", r, "stack", string(debug.Stack()))
-				}
-			}()
-
-			s.wg.Add(1)
-			defer s.wg.Done()
-
-			if s.metrics.enabled {
-				s.metrics.RequestWebsocket.Inc()
-			}
-
-			var request jsonrpc.JsonRpcRequest
-			if err := json.Unmarshal(message, &request); err != nil {
-				conn.send(jsonrpc.NewJsonRpcErrorResponse(jsonrpc.ParseError, "invalid request", err.Error(), nil))
-				return
-			}
+		// Stops reading until a request completes when the connection has too many in flight
+		if !conn.acquireInFlight() {
+			return
+		}
 
-			conn.send(s.handleJsonRpcRequest(ctx, &request))
-		}()
+		s.serveConnMessage(conn.ctx, conn.subscriptions, conn.sendRaw, message, s.metrics.RequestWebsocket, conn.releaseInFlight)
 	}
 }
 
-func (s *Server) websocketWriteLoop(conn *Conn, doneChan <-chan struct{}) {
-	ticker := time.NewTicker(pingPeriod)
+func (s *Server) websocketWriteLoop(conn *Conn) {
+	defer s.wg.Done()
+
+	writeTimeout := s.cfg.Websocket.writeTimeout()
+
+	ticker := time.NewTicker(s.cfg.Websocket.pingInterval())
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-s.shutdownChan:
+			conn.cancel()
+
 			closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Server closing connection")
-			if err := conn.WriteMessage(websocket.CloseMessage, closeMsg); err != nil {
+			if err := conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeTimeout)); err != nil {
 				log.Error(context.Background(), "websocketWriteLoop: failed to write close message", "ip", conn.IP, "err", err)
 			}
+
+			// Leave the client some time to answer the close frame before the read loop gives up
+			conn.SetReadDeadline(time.Now().Add(closeGracePeriod))
 			return
 
-		case <-doneChan:
+		case <-conn.doneChan:
 			return
 
 		case <-ticker.C:
-			deadline := time.Now().Add(writeWait)
+			deadline := time.Now().Add(writeTimeout)
 			if err := conn.WriteControl(websocket.PingMessage, []byte{}, deadline); err != nil {
 				log.Error(context.Background(), "websocketWriteLoop: failed to write ping message", "ip", conn.IP, "err", err)
 				return
 			}
 
 		case msg := <-conn.sendChan:
-			deadline := time.Now().Add(writeWait)
+			deadline := time.Now().Add(writeTimeout)
 			conn.SetWriteDeadline(deadline)
 
 			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
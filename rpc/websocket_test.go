@@ -0,0 +1,337 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FastLane-Labs/fastlane-json-rpc/testutils"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWebsocketTestServer(t *testing.T, wsCfg *WebsocketConfig, api Api) *Server {
+	wsCfg.Enabled = true
+
+	return newTestServer(t, &RpcConfig{
+		HTTP: &HttpConfig{
+			Enabled: true,
+		},
+		Websocket: wsCfg,
+	}, api)
+}
+
+func TestServer_WebsocketConnectionLimits(t *testing.T) {
+	testCases := []struct {
+		name           string
+		cfg            *WebsocketConfig
+		expectedStatus int
+		expectedReason string
+	}{
+		{
+			name:           "max connections",
+			cfg:            &WebsocketConfig{MaxConnections: 2},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedReason: "max_connections",
+		},
+		{
+			name:           "max connections per ip",
+			cfg:            &WebsocketConfig{MaxConnections: 10, MaxConnectionsPerIP: 2},
+			expectedStatus: http.StatusTooManyRequests,
+			expectedReason: "max_connections_per_ip",
+		},
+	}
+
+	for _, tc := range testCases {
+		s := newWebsocketTestServer(t, tc.cfg, testutils.NewMockRpcAdapter())
+		url := "ws://" + s.Addr().String()
+
+		conns := make([]*websocket.Conn, 0, 2)
+		for i := 0; i < 2; i++ {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("%s: failed to dial: %v", tc.name, err)
+			}
+			defer conn.Close()
+			conns = append(conns, conn)
+		}
+
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.Error(t, err, tc.name)
+		if assert.NotNil(t, resp, tc.name) {
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, tc.name)
+		}
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(s.metrics.WebsocketRejected.WithLabelValues(tc.expectedReason)), tc.name)
+
+		// Closing a connection releases its slot
+		conns[0].Close()
+
+		assert.Eventually(t, func() bool {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		}, 2*time.Second, 20*time.Millisecond, tc.name)
+	}
+}
+
+func TestServer_WebsocketMaxMessageSize(t *testing.T) {
+	s := newWebsocketTestServer(t, &WebsocketConfig{MaxMessageSize: 128}, testutils.NewMockRpcAdapter())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer conn.Close()
+
+	// Messages within the limit are served
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"mock_methodA","params":[1,false],"id":1}`)))
+	_, message, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","result":"mock_methodA success","id":1}`, string(message))
+
+	// Larger messages close the connection
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"mock_methodA","params":["`+strings.Repeat("a", 256)+`",false],"id":2}`)))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), err)
+
+	assert.Eventually(t, func() bool {
+		return promtestutil.ToFloat64(s.metrics.WebsocketOversized) == 1.0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_WebsocketMaxInFlight(t *testing.T) {
+	var (
+		started = make(chan struct{}, 2)
+		release = make(chan struct{})
+	)
+
+	registry := NewRegistry()
+	err := registry.Register("test_wait", func() string {
+		started <- struct{}{}
+		<-release
+		return "done"
+	})
+	assert.NoError(t, err)
+
+	s := newWebsocketTestServer(t, &WebsocketConfig{MaxInFlight: 1}, registry)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"test_wait","id":1}`)))
+	}
+
+	// The second request waits for the first one to complete
+	<-started
+	assert.Eventually(t, func() bool {
+		return promtestutil.ToFloat64(s.metrics.WebsocketThrottled) == 1.0
+	}, time.Second, 10*time.Millisecond)
+
+	select {
+	case <-started:
+		t.Fatal("second request started while the first one is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		_, message, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","result":"done","id":1}`, string(message))
+	}
+}
+
+// newTestWebsocketConn returns the server side of a websocket connection.
+func newTestWebsocketConn(t *testing.T) *websocket.Conn {
+	connChan := make(chan *websocket.Conn, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %v", err)
+			return
+		}
+		connChan <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn := <-connChan
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestConn_SlowConsumerPolicy(t *testing.T) {
+	testCases := []struct {
+		cfg              *WebsocketConfig
+		expectedAction   string
+		expectedCanceled bool
+	}{
+		{
+			cfg:            &WebsocketConfig{SendBufferSize: 1, SlowConsumerPolicy: SlowConsumerDrop},
+			expectedAction: "dropped",
+		},
+		{
+			cfg:              &WebsocketConfig{SendBufferSize: 1, SlowConsumerPolicy: SlowConsumerDisconnect},
+			expectedAction:   "disconnected",
+			expectedCanceled: true,
+		},
+		{
+			cfg:              &WebsocketConfig{SendBufferSize: 1, SlowConsumerTimeout: 20 * time.Millisecond},
+			expectedAction:   "disconnected",
+			expectedCanceled: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		name := string(tc.cfg.SlowConsumerPolicy)
+		metrics := NewRpcMetrics(prometheus.NewRegistry())
+		conn := newConn(context.Background(), newTestWebsocketConn(t), tc.cfg, metrics)
+
+		conn.sendRaw([]byte("first"))
+		conn.sendRaw([]byte("second"))
+
+		assert.Len(t, conn.sendChan, 1, name)
+		assert.Equal(t, "first", string(<-conn.sendChan), name)
+		assert.Equal(t, 1.0, promtestutil.ToFloat64(metrics.WebsocketSlow.WithLabelValues(tc.expectedAction)), name)
+		assert.Equal(t, tc.expectedCanceled, conn.ctx.Err() != nil, name)
+	}
+
+	// Blocked messages are queued once there is room
+	metrics := NewRpcMetrics(prometheus.NewRegistry())
+	conn := newConn(context.Background(), newTestWebsocketConn(t), &WebsocketConfig{SendBufferSize: 1}, metrics)
+
+	conn.sendRaw([]byte("first"))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-conn.sendChan
+	}()
+	conn.sendRaw([]byte("second"))
+
+	assert.Equal(t, "second", string(<-conn.sendChan))
+	assert.Equal(t, 1.0, promtestutil.ToFloat64(metrics.WebsocketSlow.WithLabelValues("blocked")))
+
+	// Unknown policies are rejected
+	_, err := NewServer(&RpcConfig{Host: "127.0.0.1", Websocket: &WebsocketConfig{SlowConsumerPolicy: "unknown"}}, testutils.NewMockRpcAdapter(), nil, nil)
+	assert.ErrorIs(t, err, ErrInvalidSlowConsumerPolicy)
+}
+
+func TestServer_WebsocketUpgraderOptions(t *testing.T) {
+	s := newWebsocketTestServer(t, &WebsocketConfig{
+		PingInterval:      20 * time.Millisecond,
+		PongTimeout:       200 * time.Millisecond,
+		EnableCompression: true,
+		AllowedOrigins:    []string{"https://app.fastlane.xyz"},
+		Subprotocols:      []string{"jsonrpc"},
+	}, testutils.NewMockRpcAdapter())
+	url := "ws://" + s.Addr().String()
+
+	// Origins
+	testCases := []struct {
+		origin   string
+		expected bool
+	}{
+		{"", true},
+		{"https://app.fastlane.xyz", true},
+		{"HTTPS://APP.FASTLANE.XYZ", true},
+		{"https://evil.xyz", false},
+	}
+
+	for _, tc := range testCases {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if tc.expected {
+			assert.NoError(t, err, tc.origin)
+			conn.Close()
+		} else {
+			assert.Error(t, err, tc.origin)
+		}
+	}
+
+	// Subprotocol and compression negotiation
+	dialer := &websocket.Dialer{
+		Subprotocols:      []string{"unknown", "jsonrpc"},
+		EnableCompression: true,
+	}
+
+	conn, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer conn.Close()
+
+	assert.Equal(t, "jsonrpc", conn.Subprotocol())
+	assert.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+	// Pings are sent every ping interval
+	pings := make(chan struct{}, 10)
+	conn.SetPingHandler(func(string) error {
+		pings <- struct{}{}
+		return nil
+	})
+
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for ping")
+		}
+	}
+
+	// Silent connections are closed after the pong timeout
+	silent, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	defer silent.Close()
+
+	silent.SetPingHandler(func(string) error { return nil })
+	silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	start := time.Now()
+	for {
+		if _, _, err := silent.ReadMessage(); err != nil {
+			break
+		}
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The ping interval must be shorter than the pong timeout
+	_, err = NewServer(&RpcConfig{Host: "127.0.0.1", Websocket: &WebsocketConfig{PingInterval: time.Minute}}, testutils.NewMockRpcAdapter(), nil, nil)
+	assert.ErrorIs(t, err, ErrInvalidPingInterval)
+}
@@ -3,14 +3,45 @@ package testutils
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
 
 	rpcContext "github.com/FastLane-Labs/fastlane-json-rpc/rpc/context"
+	"github.com/FastLane-Labs/fastlane-json-rpc/rpc/jsonrpc"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 type MockRpcAdapter struct{}
 
+type MockRevertError struct {
+	Reason string
+}
+
+func (e *MockRevertError) Error() string {
+	return "execution reverted"
+}
+
+func (e *MockRevertError) ErrorCode() int {
+	return jsonrpc.ExecutionReverted
+}
+
+func (e *MockRevertError) ErrorData() interface{} {
+	return e.Reason
+}
+
+type MockOptions struct {
+	Nonce hexutil.Uint64 `json:"nonce"`
+}
+
+type MockParams struct {
+	Name    string          `json:"name"`
+	Amount  uint64          `json:"amount"`
+	Comment *string         `json:"comment"`
+	Tags    []string        `json:"tags,omitempty"`
+	To      *common.Address `json:"to"`
+}
+
 func NewMockRpcAdapter() *MockRpcAdapter {
 	return &MockRpcAdapter{}
 }
@@ -23,6 +54,14 @@ func (r *MockRpcAdapter) RuntimeMethod(methodName string) reflect.Value {
 	return reflect.Value{}
 }
 
+func (r *MockRpcAdapter) ParamNames(methodName string) []string {
+	switch methodName {
+	case "mock_methodA":
+		return []string{"param1", "shouldError"}
+	}
+	return nil
+}
+
 func (r *MockRpcAdapter) Mock_methodA(param1 uint64, shouldError bool) (string, error) {
 	if shouldError {
 		return "", errors.New("mock_methodA error")
@@ -51,6 +90,35 @@ func (r *MockRpcAdapter) Mock_methodD(param1 float64, shouldError bool) (string,
 	return (&hexutil.Bytes{1, 1, 1}).String(), nil
 }
 
+func (r *MockRpcAdapter) Mock_methodE(to common.Address, value *hexutil.Big, tags []string, opts *MockOptions) (string, error) {
+	if value == nil || opts == nil {
+		return "", errors.New("mock_methodE error")
+	}
+	return fmt.Sprintf("%s:%s:%d:%d", to.Hex(), value.String(), len(tags), opts.Nonce), nil
+}
+
+func (r *MockRpcAdapter) Mock_methodF(params MockParams) (string, error) {
+	return fmt.Sprintf("%s:%d:%d", params.Name, params.Amount, len(params.Tags)), nil
+}
+
+func (r *MockRpcAdapter) Mock_methodG(mode string) error {
+	switch mode {
+	case "revert":
+		return fmt.Errorf("mock_methodG: %w", &MockRevertError{Reason: "0x08c379a0"})
+	case "limit":
+		return jsonrpc.NewJsonRpcError(jsonrpc.LimitExceeded, "mock_methodG limit exceeded", nil)
+	}
+	return errors.New("mock_methodG error")
+}
+
+func (r *MockRpcAdapter) Mock_methodH(value string) string {
+	return value
+}
+
+func (r *MockRpcAdapter) Mock_methodPanic() error {
+	panic("mock_methodPanic")
+}
+
 func (r *MockRpcAdapter) Mock_runtime_method(param1 float64, shouldError bool) (string, error) {
 	if shouldError {
 		return "", errors.New("mock_runtime_method error")